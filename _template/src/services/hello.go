@@ -2,7 +2,6 @@ package services
 
 import (
 	"context"
-	"log"
 
 	. "github.com/Wizcorp/goal/_template/src/proto"
 	. "github.com/Wizcorp/goal/_template/src/systems"
@@ -12,18 +11,19 @@ import (
 )
 
 func init() {
-	hooks := (*GoalHooks)(nil)
-	service := &HelloService{}
-	server := NewHelloServer(service, hooks)
+	RegisterServiceFactory(HelloPathPrefix, func() (GoalServiceServer, GoalService, *GoalHooks) {
+		hooks := (*GoalHooks)(nil)
+		service := &HelloService{}
 
-	RegisterService(HelloPathPrefix, server, service, hooks)
+		return NewHelloServer(service, hooks), service, hooks
+	})
 }
 
 type HelloService struct {
 	game Game
 }
 
-func (hello *HelloService) Setup(server GoalServer, config *GoalConfig) error {
+func (hello *HelloService) Setup(ctx context.Context, server GoalServer, config *GoalConfig) error {
 	hello.game = (*server.GetSystem("game")).(Game)
 
 	return nil
@@ -35,13 +35,12 @@ func (hello *HelloService) HelloWorld(ctx context.Context, message *HelloRequest
 	}, nil
 }
 
-func (hello *HelloService) HandleHello(ctx context.Context, message *HelloRequest) {
-	emitter := ctx.Value("emitter").(GoalServiceEmitter)
-	err := emitter(ctx, &HelloResponse{
+func (hello *HelloService) RegisterMessageHandlers(services GoalServices) {
+	OnMessage(services, hello.HandleHello)
+}
+
+func (hello *HelloService) HandleHello(ctx context.Context, message *HelloRequest) error {
+	return GetSession(ctx).Emit(ctx, &HelloResponse{
 		Message: hello.game.SayHello(message.Name),
 	})
-
-	if err != nil {
-		log.Printf("%v", err)
-	}
 }
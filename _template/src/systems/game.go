@@ -1,52 +1,67 @@
 package systems
 
 import (
+	"context"
+
 	. "github.com/Wizcorp/goal/_template/src/api"
+	. "github.com/Wizcorp/goal/_template/src/proto"
 
 	. "github.com/Wizcorp/goal/src/api"
 	. "github.com/Wizcorp/goal/src/systems"
 )
 
 func init() {
-	RegisterSystem(3, "game", NewGame())
+	RegisterSystemFactory(3, "game", func() GoalSystem {
+		return NewGame()
+	})
+	RegisterConfigSchema("game", GoalSystemConfig{})
 }
 
 type Game interface {
 	GoalSystem
 	SayHello(name string) string
+	SendHello(ctx context.Context, sessionID string, name string) error
 }
 
 type game struct {
-	Status Status
+	GoalStatusTracker
+	services GoalServices
 }
 
 func NewGame() *game {
 	return &game{}
 }
 
-func (game *game) Setup(server GoalServer, config *GoalConfig) error {
-	configData := config.Get("")
+func (game *game) Setup(ctx context.Context, server GoalServer, config *GoalConfig) error {
 	logger := (*server.GetSystem("logger")).(GoalLogger).GetInstance()
 	logger.WithFields(LogFields{
-		"config": configData,
+		"config": config,
 	}).Info("Game configuration")
-	game.Status = UpStatus
+	game.services = (*server.GetSystem("services")).(GoalServices)
+	game.SetStatus(UpStatus, "")
 
 	return nil
 }
 
-func (game *game) Teardown(server GoalServer, config *GoalConfig) error {
+func (game *game) Teardown(ctx context.Context, server GoalServer, config *GoalConfig) error {
 	logger := (*server.GetSystem("logger")).(GoalLogger).GetInstance()
 	logger.Info("Tearing down game")
-	game.Status = DownStatus
+	game.SetStatus(DownStatus, "")
 
 	return nil
 }
 
-func (game *game) GetStatus() Status {
-	return game.Status
+func (game *game) GetDependencies() []string {
+	return []string{"logger", "services"}
 }
 
 func (game *game) SayHello(name string) string {
 	return Concat("Hello, " + name)
 }
+
+// SendHello pushes a greeting to a connected player
+func (game *game) SendHello(ctx context.Context, sessionID string, name string) error {
+	return game.services.EmitTo(ctx, sessionID, &HelloResponse{
+		Message: game.SayHello(name),
+	})
+}
@@ -1,31 +1,50 @@
 module github.com/Wizcorp/goal
 
+go 1.18
+
 require (
 	github.com/AsynkronIT/protoactor-go v0.0.0-20190103141422-46ce3cc7fd18
-	github.com/Workiva/go-datastructures v1.0.50 // indirect
 	github.com/briandowns/spinner v0.0.0-20190126160308-b298438e1f0d
-	github.com/danwakefield/fnmatch v0.0.0-20160403171240-cbb64ac3d964 // indirect
 	github.com/denormal/go-gitignore v0.0.0-20180930084346-ae8ad1d07817
-	github.com/emirpasic/gods v1.12.0 // indirect
 	github.com/fatih/color v1.7.0
 	github.com/go-errors/errors v1.0.1
-	github.com/gogo/protobuf v1.2.0 // indirect
+	github.com/gogo/protobuf v1.2.0
 	github.com/golang/protobuf v1.2.1-0.20190109072247-347cf4a86c1c
 	github.com/gookit/config v0.0.0-20190118015358-4e63bfd501c3
 	github.com/gorilla/websocket v1.4.0
 	github.com/hashicorp/consul v1.4.2
+	github.com/prometheus/client_golang v0.9.2
+	github.com/sirupsen/logrus v1.3.0
+	github.com/spf13/cobra v0.0.3
+	github.com/twitchtv/twirp v5.5.1+incompatible
+	gopkg.in/yaml.v2 v2.2.2
+)
+
+require (
+	github.com/Workiva/go-datastructures v1.0.50 // indirect
+	github.com/armon/go-metrics v0.0.0-20180917152333-f0300d1749da // indirect
+	github.com/beorn7/perks v0.0.0-20180321164747-3a771d992973 // indirect
+	github.com/danwakefield/fnmatch v0.0.0-20160403171240-cbb64ac3d964 // indirect
+	github.com/emirpasic/gods v1.12.0 // indirect
 	github.com/hashicorp/go-cleanhttp v0.5.0 // indirect
+	github.com/hashicorp/go-immutable-radix v1.0.0 // indirect
 	github.com/hashicorp/go-rootcerts v1.0.0 // indirect
+	github.com/hashicorp/golang-lru v0.5.0 // indirect
 	github.com/hashicorp/serf v0.8.2 // indirect
 	github.com/imdario/mergo v0.3.7 // indirect
+	github.com/mattn/go-colorable v0.0.9 // indirect
 	github.com/mattn/go-isatty v0.0.4 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
 	github.com/mitchellh/mapstructure v1.1.2 // indirect
 	github.com/orcaman/concurrent-map v0.0.0-20190107190726-7ed82d9cb717 // indirect
-	github.com/prometheus/client_golang v0.9.2
-	github.com/sirupsen/logrus v1.3.0
-	github.com/spf13/cobra v0.0.3
+	github.com/prometheus/client_model v0.0.0-20180712105110-5c3871d89910 // indirect
+	github.com/prometheus/common v0.0.0-20181126121408-4724e9255275 // indirect
+	github.com/prometheus/procfs v0.0.0-20181204211112-1dc9a6cbc91a // indirect
 	github.com/spf13/pflag v1.0.3 // indirect
-	github.com/twitchtv/twirp v5.5.1+incompatible
+	golang.org/x/crypto v0.0.0-20181029021203-45a5f77698d3 // indirect
+	golang.org/x/net v0.0.0-20181201002055-351d144fa1fc // indirect
+	golang.org/x/sys v0.0.0-20181026203630-95b1ffbd15a5 // indirect
+	golang.org/x/text v0.3.0 // indirect
+	google.golang.org/genproto v0.0.0-20180831171423-11092d34479b // indirect
 	google.golang.org/grpc v1.18.0 // indirect
-	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
@@ -1,9 +1,12 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/go-errors/errors"
@@ -13,69 +16,84 @@ import (
 
 type GoalConfig = configlib.Config
 
-var config *GoalConfig
+// GoalConfigSources maps the path of every configuration leaf to
+// the layer (file or environment variable) which supplied its value
+type GoalConfigSources map[string]string
 
 var configPath = os.Getenv("GOAL_CONFIGS")
 var goalEnv = os.Getenv("GOAL_ENV")
 
+// Environment variables used to locate configuration files, which
+// are not treated as configuration overrides
+var loaderVariables = map[string]bool{
+	"GOAL_CONFIGS":         true,
+	"GOAL_ENV":             true,
+	"GOAL_SECRET_KEY_FILE": true,
+}
+
 func NewEmptyConfig(prefix string) *GoalConfig {
 	return configlib.NewEmpty(prefix)
 }
 
-// LoadConfig primes the server's configuration file(s)
-func LoadConfig() *GoalConfig {
-	if config != nil {
-		return config
-	}
-	config = configlib.NewEmpty("goal")
-
-	if configPath == "" {
-		configPath = "./configs"
-	}
+// BuildConfig reads the server's configuration file(s) and environment
+// variables into a new configuration, along with the source of each value
+func BuildConfig() (*GoalConfig, GoalConfigSources, error) {
+	newConfig := configlib.NewEmpty("goal")
+	sources := GoalConfigSources{}
 
-	config.WithOptions(configlib.ParseEnv)
-	config.AddDriver(yaml.Driver)
+	newConfig.WithOptions(configlib.ParseEnv)
+	newConfig.AddDriver(yaml.Driver)
 
-	err := loadConfig("%s/default.yml", configPath)
+	err := loadConfig(newConfig, sources, "%s/default.yml", getConfigPath())
 	if err != nil {
-		log.Panicf("Failed load default config: %v", err)
+		return nil, nil, errors.Errorf("Failed load default config: %v", err)
 	}
 
 	if goalEnv != "" {
-		err := loadConfig("%s/%s.yml", configPath, goalEnv)
+		err := loadConfig(newConfig, sources, "%s/%s.yml", getConfigPath(), goalEnv)
 		if err != nil {
-			log.Panicf("Failed to load environment configuration: %v", err)
+			return nil, nil, errors.Errorf("Failed to load environment configuration: %v", err)
 		}
 	}
 
-	err = loadConfig("%s/custom.yml", configPath)
+	err = loadConfig(newConfig, sources, "%s/custom.yml", getConfigPath())
 	if err != nil {
-		log.Panicf("Failed to load custom configuration: %v", err)
+		return nil, nil, errors.Errorf("Failed to load custom configuration: %v", err)
 	}
 
-	for _, envEntry := range os.Environ() {
-		if !strings.HasPrefix(envEntry, "GOAL_") {
-			continue
-		}
+	err = applyEnvOverrides(newConfig, sources)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = resolveSecrets(newConfig)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		keyVal := strings.Split(envEntry, "=")
-		key := strings.Replace(
-			strings.ToLower(keyVal[0]),
-			"_",
-			".",
-			-1,
-		)
-		val := strings.Join(keyVal[1:], "=")
-		config.Set(key, val)
+	err = ValidateConfig(newConfig)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return config
+	return newConfig, sources, nil
 }
 
 func GetSubconfig(path string, config *GoalConfig) (*GoalConfig, error) {
 	subpath := fmt.Sprintf("goal.%s", path)
-	data := config.Get(subpath)
-	subconfig := NewEmptyConfig(subpath)
+
+	return extractSubconfig(subpath, subpath, config)
+}
+
+// GetNestedSubconfig returns the subconfig found at the given key of
+// another subconfig, such as the settings of a single service
+func GetNestedSubconfig(key string, config *GoalConfig) (*GoalConfig, error) {
+	return extractSubconfig(fmt.Sprintf("%s.%s", config.Name(), key), key, config)
+}
+
+func extractSubconfig(name string, key string, config *GoalConfig) (*GoalConfig, error) {
+	data := config.Get(key)
+	subconfig := NewEmptyConfig(name)
 
 	if data == nil {
 		return subconfig, nil
@@ -99,14 +117,254 @@ func GetSubconfig(path string, config *GoalConfig) (*GoalConfig, error) {
 		}
 	}
 
+	err := resolveSecrets(subconfig)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
 	return subconfig, nil
 }
 
-func loadConfig(str string, args ...interface{}) error {
+// SubconfigChanged returns true if the subconfig found at the given
+// path differs between two configurations
+func SubconfigChanged(path string, oldConfig *GoalConfig, newConfig *GoalConfig) bool {
+	subpath := fmt.Sprintf("goal.%s", path)
+
+	return !reflect.DeepEqual(oldConfig.Get(subpath), newConfig.Get(subpath))
+}
+
+// NestedSubconfigChanged returns true if the subconfig found at the
+// given key of another subconfig differs between two configurations
+func NestedSubconfigChanged(key string, oldConfig *GoalConfig, newConfig *GoalConfig) bool {
+	return !reflect.DeepEqual(oldConfig.Get(key), newConfig.Get(key))
+}
+
+// applyEnvOverrides sets configuration values based on GOAL_* environment
+// variables.
+//
+// Variable names are split on underscores (a double underscore stands for a
+// literal one), and each part is matched case-insensitively against existing
+// keys; values are converted to the type of the value they override, and
+// values starting with [ or { are decoded as JSON.
+func applyEnvOverrides(target *GoalConfig, sources GoalConfigSources) error {
+	fields := getSchemaFields()
+	problems := []string{}
+
+	for _, envEntry := range os.Environ() {
+		keyVal := strings.SplitN(envEntry, "=", 2)
+		name := keyVal[0]
+
+		if !strings.HasPrefix(name, "GOAL_") || loaderVariables[name] {
+			continue
+		}
+
+		key := resolveEnvKey(target, fields, name)
+		value, err := coerceEnvValue(keyVal[1], target.Get(key), fields[key])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid value in %s: %v", key, name, err))
+			continue
+		}
+
+		err = target.Set(key, value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: could not be set from %s: %v", key, name, err))
+			continue
+		}
+
+		for path := range FlattenConfig(key, value) {
+			sources[path] = "env:" + name
+		}
+	}
+
+	if len(problems) > 0 {
+		return &GoalConfigError{Problems: problems}
+	}
+
+	return nil
+}
+
+func resolveEnvKey(target *GoalConfig, fields map[string]reflect.Type, name string) string {
+	segments := splitEnvName(name)
+	path := strings.ToLower(segments[0])
+
+	for _, segment := range segments[1:] {
+		candidates := []string{}
+		if entries, ok := toStringMap(target.Get(path)); ok {
+			for key := range entries {
+				candidates = append(candidates, key)
+			}
+		}
+
+		for fieldPath := range fields {
+			if strings.HasPrefix(fieldPath, path+".") && !strings.Contains(fieldPath[len(path)+1:], ".") {
+				candidates = append(candidates, fieldPath[len(path)+1:])
+			}
+		}
+
+		key := strings.ToLower(segment)
+		for _, candidate := range candidates {
+			if strings.EqualFold(candidate, segment) {
+				key = candidate
+				break
+			}
+		}
+
+		path = path + "." + key
+	}
+
+	return path
+}
+
+func splitEnvName(name string) []string {
+	segments := []string{}
+	current := strings.Builder{}
+
+	for i := 0; i < len(name); i++ {
+		if name[i] != '_' {
+			current.WriteByte(name[i])
+			continue
+		}
+
+		if i+1 < len(name) && name[i+1] == '_' {
+			current.WriteByte('_')
+			i++
+			continue
+		}
+
+		segments = append(segments, current.String())
+		current.Reset()
+	}
+
+	return append(segments, current.String())
+}
+
+func coerceEnvValue(raw string, current interface{}, field reflect.Type) (interface{}, error) {
+	trimmed := strings.TrimSpace(raw)
+	if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{") {
+		var value interface{}
+		err := json.Unmarshal([]byte(trimmed), &value)
+		if err != nil {
+			return nil, err
+		}
+
+		return value, nil
+	}
+
+	kind := reflect.String
+	if field != nil && field != durationType {
+		kind = field.Kind()
+	} else if current != nil {
+		kind = reflect.TypeOf(current).Kind()
+	}
+
+	switch kind {
+	case reflect.Bool:
+		return strconv.ParseBool(trimmed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value, err := strconv.ParseInt(trimmed, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		return int(value), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value, err := strconv.ParseUint(trimmed, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		return int(value), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(trimmed, 64)
+	case reflect.Slice, reflect.Map:
+		return nil, errors.Errorf("expected a JSON value")
+	}
+
+	return raw, nil
+}
+
+func getConfigPath() string {
+	if configPath == "" {
+		return "./configs"
+	}
+
+	return configPath
+}
+
+// FlattenConfig lists the leaves found in a configuration tree, indexed
+// by their dot-separated path
+func FlattenConfig(prefix string, data interface{}) map[string]interface{} {
+	leaves := map[string]interface{}{}
+	flattenConfig(prefix, data, leaves)
+
+	return leaves
+}
+
+func flattenConfig(prefix string, data interface{}, leaves map[string]interface{}) {
+	entries, isMap := toStringMap(data)
+	if !isMap || data == nil {
+		leaves[prefix] = data
+		return
+	}
+
+	for key, val := range entries {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		flattenConfig(path, val, leaves)
+	}
+}
+
+// UnflattenConfig builds a configuration tree from a list of
+// leaves indexed by their dot-separated path
+func UnflattenConfig(leaves map[string]interface{}) map[string]interface{} {
+	paths := []string{}
+	for path := range leaves {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	tree := map[string]interface{}{}
+	for _, path := range paths {
+		keys := strings.Split(path, ".")
+		node := tree
+
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := node[key].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				node[key] = child
+			}
+
+			node = child
+		}
+
+		node[keys[len(keys)-1]] = leaves[path]
+	}
+
+	return tree
+}
+
+func loadConfig(target *GoalConfig, sources GoalConfigSources, str string, args ...interface{}) error {
 	file := fmt.Sprintf(str, args...)
 	if _, err := os.Stat(file); os.IsNotExist(err) {
 		return nil
 	}
 
-	return config.LoadFiles(file)
+	layer := configlib.NewEmpty(file)
+	layer.AddDriver(yaml.Driver)
+
+	err := layer.LoadFiles(file)
+	if err != nil {
+		return err
+	}
+
+	for path := range FlattenConfig("", layer.Data()) {
+		sources[path] = file
+	}
+
+	return target.LoadFiles(file)
 }
@@ -0,0 +1,59 @@
+package api_test
+
+import (
+	"testing"
+
+	. "github.com/Wizcorp/goal/src/api"
+)
+
+type envConfig struct {
+	ShutdownTimeout int      `config:"shutdownTimeout" default:"10"`
+	Enable          bool     `config:"enable"`
+	Hosts           []string `config:"hosts"`
+	ReplicaSet      string   `config:"replica_set"`
+}
+
+func TestEnvOverrides(t *testing.T) {
+	RegisterConfigSchema("envtest", envConfig{})
+
+	t.Setenv("GOAL_ENVTEST_SHUTDOWNTIMEOUT", "5")
+	t.Setenv("GOAL_ENVTEST_ENABLE", "true")
+	t.Setenv("GOAL_ENVTEST_HOSTS", `["a", "b"]`)
+	t.Setenv("GOAL_ENVTEST_REPLICA__SET", "rs0")
+
+	config, sources, err := BuildConfig()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if config.Get("goal.envtest.shutdownTimeout") != 5 {
+		t.Errorf("Camel-cased key was not overridden: %#v", config.Get("goal.envtest"))
+	}
+
+	if config.Get("goal.envtest.enable") != true {
+		t.Errorf("Value was not converted to a boolean: %#v", config.Get("goal.envtest.enable"))
+	}
+
+	if len(config.Strings("goal.envtest.hosts")) != 2 {
+		t.Errorf("JSON list was not decoded: %#v", config.Get("goal.envtest.hosts"))
+	}
+
+	if config.String("goal.envtest.replica_set") != "rs0" {
+		t.Errorf("Escaped underscore was not preserved: %#v", config.Get("goal.envtest"))
+	}
+
+	if sources["goal.envtest.enable"] != "env:GOAL_ENVTEST_ENABLE" {
+		t.Errorf("Source was not recorded: %v", sources)
+	}
+}
+
+func TestEnvOverridesRejectInvalidValues(t *testing.T) {
+	RegisterConfigSchema("envtest", envConfig{})
+
+	t.Setenv("GOAL_ENVTEST_ENABLE", "maybe")
+
+	_, _, err := BuildConfig()
+	if err == nil {
+		t.Error("Invalid boolean was accepted")
+	}
+}
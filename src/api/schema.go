@@ -0,0 +1,533 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-errors/errors"
+)
+
+// GoalSystemConfig holds the settings the server reads from every system's
+// subconfig; embed it in system config schemas so these keys are accepted
+type GoalSystemConfig struct {
+	SetupTimeout    int64 `config:"setupTimeout" default:"30" validate:"min=1"`
+	TeardownTimeout int64 `config:"teardownTimeout" default:"30" validate:"min=1"`
+}
+
+// GoalConfigError lists all the problems found while decoding a configuration
+type GoalConfigError struct {
+	Problems []string
+}
+
+func (configError *GoalConfigError) Error() string {
+	return fmt.Sprintf("Invalid configuration:\n  - %s", strings.Join(configError.Problems, "\n  - "))
+}
+
+// RedactedConfigValue replaces the value of secrets when
+// configurations are printed or logged
+const RedactedConfigValue = "[redacted]"
+
+var schemas = map[string]reflect.Type{}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// RegisterConfigSchema registers the struct describing the subconfig found at
+// the given path; configurations are checked against all registered schemas
+// when they are loaded.
+//
+// Fields are mapped using the `config` tag (defaulting to the field name in
+// lower camel case), and can use the `default`, `required:"true"`,
+// `secret:"true"` and `validate` tags; validate supports `min=N`, `max=N`
+// and `oneof=a|b|c`.
+func RegisterConfigSchema(path string, schema interface{}) {
+	schemaType := reflect.TypeOf(schema)
+	for schemaType.Kind() == reflect.Ptr {
+		schemaType = schemaType.Elem()
+	}
+
+	if schemaType.Kind() != reflect.Struct {
+		log.Panicf("Config schema for %s must be a struct (submitted: %v)", path, schemaType)
+	}
+
+	schemas[path] = schemaType
+}
+
+// ValidateConfig checks a configuration against all registered schemas;
+// every subconfig of goal must have a registered schema
+func ValidateConfig(config *GoalConfig) error {
+	paths := []string{}
+	for path := range schemas {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	problems := []string{}
+	known := map[string]bool{}
+	for _, path := range paths {
+		subpath := fmt.Sprintf("goal.%s", path)
+		target := reflect.New(schemas[path]).Elem()
+		problems = append(problems, decodeValue(subpath, config.Get(subpath), target)...)
+		known[strings.SplitN(path, ".", 2)[0]] = true
+	}
+
+	// Keys without a schema are most likely typos, such as goal.htpp
+	entries, _ := toStringMap(config.Get("goal"))
+	unknown := []string{}
+	for key := range entries {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+
+	sort.Strings(unknown)
+
+	for _, key := range unknown {
+		problems = append(problems, fmt.Sprintf("goal.%s: unknown key", key))
+	}
+
+	if len(problems) > 0 {
+		return &GoalConfigError{Problems: problems}
+	}
+
+	return nil
+}
+
+// DecodeConfig decodes a subconfig into the struct pointed by target,
+// applying defaults and validation rules
+func DecodeConfig(config *GoalConfig, target interface{}) error {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("Config target must be a pointer to a struct (submitted: %T)", target)
+	}
+
+	problems := decodeValue(config.Name(), config.Data(), value.Elem())
+	if len(problems) > 0 {
+		return &GoalConfigError{Problems: problems}
+	}
+
+	return nil
+}
+
+// GetConfigDefaults returns the default values declared by registered
+// schemas, indexed by the path of the leaf they apply to
+func GetConfigDefaults() map[string]interface{} {
+	defaults := map[string]interface{}{}
+
+	for path, schema := range schemas {
+		walkSchema("goal."+path, schema, func(fieldPath string, field reflect.StructField) {
+			defaultValue, hasDefault := field.Tag.Lookup("default")
+			if !hasDefault {
+				return
+			}
+
+			if field.Type == durationType {
+				defaults[fieldPath] = defaultValue
+				return
+			}
+
+			value := reflect.New(field.Type).Elem()
+			if len(decodeValue(fieldPath, defaultValue, value)) == 0 {
+				defaults[fieldPath] = value.Interface()
+			}
+		})
+	}
+
+	return defaults
+}
+
+// IsSecretConfig returns true if a registered schema declares
+// the leaf found at the given path as secret
+func IsSecretConfig(path string) bool {
+	secret := false
+
+	for schemaPath, schema := range schemas {
+		prefix := "goal." + schemaPath
+		if path != prefix && !strings.HasPrefix(path, prefix+".") {
+			continue
+		}
+
+		walkSchema(prefix, schema, func(fieldPath string, field reflect.StructField) {
+			if field.Tag.Get("secret") != "true" {
+				return
+			}
+
+			if path == fieldPath || strings.HasPrefix(path, fieldPath+".") {
+				secret = true
+			}
+		})
+	}
+
+	return secret
+}
+
+// getSchemaFields lists the type of every field declared by
+// registered schemas, indexed by path
+func getSchemaFields() map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+
+	for path, schema := range schemas {
+		walkSchema("goal."+path, schema, func(fieldPath string, field reflect.StructField) {
+			fields[fieldPath] = field.Type
+		})
+	}
+
+	return fields
+}
+
+func walkSchema(path string, schema reflect.Type, visit func(fieldPath string, field reflect.StructField)) {
+	for i := 0; i < schema.NumField(); i++ {
+		field := schema.Field(i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			walkSchema(path, field.Type, visit)
+			continue
+		}
+
+		key := field.Tag.Get("config")
+		if key == "-" || field.PkgPath != "" {
+			continue
+		}
+
+		if key == "" {
+			key = lowerCamelCase(field.Name)
+		}
+
+		fieldPath := fmt.Sprintf("%s.%s", path, key)
+		visit(fieldPath, field)
+
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			walkSchema(fieldPath, field.Type, visit)
+		}
+	}
+}
+
+func decodeValue(path string, data interface{}, value reflect.Value) []string {
+	if secret, ok := data.(secretValue); ok {
+		data = string(secret)
+	}
+
+	if value.Type() == durationType {
+		return decodeDuration(path, data, value)
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		return decodeStruct(path, data, value)
+	case reflect.Slice:
+		return decodeSlice(path, data, value)
+	case reflect.Map:
+		return decodeMap(path, data, value)
+	case reflect.String:
+		str, ok := data.(string)
+		if !ok {
+			return []string{wrongType(path, data, value)}
+		}
+
+		value.SetString(str)
+	case reflect.Bool:
+		switch data := data.(type) {
+		case bool:
+			value.SetBool(data)
+		case string:
+			parsed, err := strconv.ParseBool(data)
+			if err != nil {
+				return []string{wrongType(path, data, value)}
+			}
+
+			value.SetBool(parsed)
+		default:
+			return []string{wrongType(path, data, value)}
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(fmt.Sprint(data), 10, value.Type().Bits())
+		if err != nil || !isScalar(data) {
+			return []string{wrongType(path, data, value)}
+		}
+
+		value.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(fmt.Sprint(data), 10, value.Type().Bits())
+		if err != nil || !isScalar(data) {
+			return []string{wrongType(path, data, value)}
+		}
+
+		value.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(fmt.Sprint(data), value.Type().Bits())
+		if err != nil || !isScalar(data) {
+			return []string{wrongType(path, data, value)}
+		}
+
+		value.SetFloat(parsed)
+	case reflect.Interface:
+		if data != nil {
+			value.Set(reflect.ValueOf(data))
+		}
+	default:
+		return []string{fmt.Sprintf("%s: unsupported field type %v", path, value.Type())}
+	}
+
+	return nil
+}
+
+func decodeStruct(path string, data interface{}, value reflect.Value) []string {
+	entries, ok := toStringMap(data)
+	if !ok {
+		return []string{wrongType(path, data, value)}
+	}
+
+	known := map[string]bool{}
+	problems := decodeFields(path, entries, value, known)
+
+	unknown := []string{}
+	for key := range entries {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+
+	sort.Strings(unknown)
+
+	for _, key := range unknown {
+		problems = append(problems, fmt.Sprintf("%s.%s: unknown key", path, key))
+	}
+
+	return problems
+}
+
+func decodeFields(path string, entries map[string]interface{}, value reflect.Value, known map[string]bool) []string {
+	problems := []string{}
+	valueType := value.Type()
+
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		fieldValue := value.Field(i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			problems = append(problems, decodeFields(path, entries, fieldValue, known)...)
+			continue
+		}
+
+		key := field.Tag.Get("config")
+		if key == "-" || field.PkgPath != "" {
+			continue
+		}
+
+		if key == "" {
+			key = lowerCamelCase(field.Name)
+		}
+
+		known[key] = true
+		fieldPath := fmt.Sprintf("%s.%s", path, key)
+		data, found := entries[key]
+
+		if !found {
+			if field.Tag.Get("required") == "true" {
+				problems = append(problems, fmt.Sprintf("%s: required value is missing", fieldPath))
+				continue
+			}
+
+			defaultValue, hasDefault := field.Tag.Lookup("default")
+			if hasDefault {
+				data = defaultValue
+			} else if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+				// Nested structs are decoded anyway so their own defaults apply
+				data = map[string]interface{}{}
+			} else {
+				continue
+			}
+		}
+
+		fieldProblems := decodeValue(fieldPath, data, fieldValue)
+		if len(fieldProblems) == 0 {
+			fieldProblems = validateValue(fieldPath, field.Tag.Get("validate"), fieldValue)
+		}
+
+		// Problems quote the values they are about, which must not leak secrets
+		if len(fieldProblems) > 0 && field.Tag.Get("secret") == "true" {
+			fieldProblems = []string{fmt.Sprintf("%s: invalid value, expected %v", fieldPath, field.Type)}
+		}
+
+		problems = append(problems, fieldProblems...)
+	}
+
+	return problems
+}
+
+func decodeSlice(path string, data interface{}, value reflect.Value) []string {
+	if data == nil {
+		return nil
+	}
+
+	items, ok := data.([]interface{})
+	if !ok {
+		return []string{wrongType(path, data, value)}
+	}
+
+	problems := []string{}
+	slice := reflect.MakeSlice(value.Type(), len(items), len(items))
+
+	for i, item := range items {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		problems = append(problems, decodeValue(itemPath, item, slice.Index(i))...)
+	}
+
+	value.Set(slice)
+
+	return problems
+}
+
+func decodeMap(path string, data interface{}, value reflect.Value) []string {
+	if value.Type().Key().Kind() != reflect.String {
+		return []string{fmt.Sprintf("%s: unsupported map key type %v", path, value.Type().Key())}
+	}
+
+	entries, ok := toStringMap(data)
+	if !ok {
+		return []string{wrongType(path, data, value)}
+	}
+
+	problems := []string{}
+	result := reflect.MakeMap(value.Type())
+
+	for key, entry := range entries {
+		item := reflect.New(value.Type().Elem()).Elem()
+		problems = append(problems, decodeValue(fmt.Sprintf("%s.%s", path, key), entry, item)...)
+		result.SetMapIndex(reflect.ValueOf(key).Convert(value.Type().Key()), item)
+	}
+
+	value.Set(result)
+
+	return problems
+}
+
+func decodeDuration(path string, data interface{}, value reflect.Value) []string {
+	str, ok := data.(string)
+	if !ok {
+		return []string{wrongType(path, data, value)}
+	}
+
+	duration, err := time.ParseDuration(str)
+	if err != nil {
+		return []string{wrongType(path, data, value)}
+	}
+
+	value.SetInt(int64(duration))
+
+	return nil
+}
+
+func validateValue(path string, rules string, value reflect.Value) []string {
+	if rules == "" {
+		return nil
+	}
+
+	problems := []string{}
+
+	for _, rule := range strings.Split(rules, ",") {
+		ruleParts := strings.SplitN(rule, "=", 2)
+		name := ruleParts[0]
+		arg := ""
+		if len(ruleParts) > 1 {
+			arg = ruleParts[1]
+		}
+
+		switch name {
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				log.Panicf("Invalid %s rule for %s: %s", name, path, arg)
+			}
+
+			measure, ok := measureValue(value)
+			if !ok {
+				log.Panicf("Rule %s cannot be applied to %s (type: %v)", name, path, value.Type())
+			}
+
+			if name == "min" && measure < limit {
+				problems = append(problems, fmt.Sprintf("%s: must be at least %s (got %v)", path, arg, value.Interface()))
+			}
+
+			if name == "max" && measure > limit {
+				problems = append(problems, fmt.Sprintf("%s: must be at most %s (got %v)", path, arg, value.Interface()))
+			}
+		case "oneof":
+			options := strings.Split(arg, "|")
+			found := false
+			for _, option := range options {
+				if fmt.Sprint(value.Interface()) == option {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				problems = append(problems, fmt.Sprintf("%s: must be one of %s (got %v)", path, strings.Join(options, ", "), value.Interface()))
+			}
+		default:
+			log.Panicf("Unknown validation rule %s for %s", name, path)
+		}
+	}
+
+	return problems
+}
+
+func measureValue(value reflect.Value) (float64, bool) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	case reflect.String, reflect.Slice, reflect.Map:
+		return float64(value.Len()), true
+	}
+
+	return 0, false
+}
+
+func toStringMap(data interface{}) (map[string]interface{}, bool) {
+	switch data := data.(type) {
+	case nil:
+		return map[string]interface{}{}, true
+	case map[string]interface{}:
+		return data, true
+	case map[interface{}]interface{}:
+		entries := map[string]interface{}{}
+		for key, val := range data {
+			entries[fmt.Sprint(key)] = val
+		}
+
+		return entries, true
+	}
+
+	return nil, false
+}
+
+func isScalar(data interface{}) bool {
+	switch data.(type) {
+	case string, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return true
+	}
+
+	return false
+}
+
+func wrongType(path string, data interface{}, value reflect.Value) string {
+	return fmt.Sprintf("%s: expected %v, got %T (%v)", path, value.Type(), data, data)
+}
+
+func lowerCamelCase(name string) string {
+	runes := []rune(name)
+	runes[0] = unicode.ToLower(runes[0])
+
+	return string(runes)
+}
@@ -0,0 +1,132 @@
+package api_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/Wizcorp/goal/src/api"
+)
+
+type testConfig struct {
+	GoalSystemConfig
+	Name     string            `config:"name" required:"true"`
+	Port     int               `config:"port" default:"8080" validate:"min=1,max=65535"`
+	Mode     string            `config:"mode" default:"fast" validate:"oneof=fast|slow"`
+	Enable   bool              `config:"enable"`
+	Interval time.Duration     `config:"interval" default:"5s"`
+	Tags     []string          `config:"tags"`
+	Labels   map[string]string `config:"labels"`
+	Limits   struct {
+		Size int `config:"size" default:"10"`
+	} `config:"limits"`
+}
+
+func TestDecodeConfig(t *testing.T) {
+	config := NewEmptyConfig("goal.test")
+	config.Set("name", "server")
+	config.Set("enable", "true")
+	config.Set("tags", []interface{}{"a", "b"})
+	config.Set("labels", map[string]interface{}{"zone": "eu"})
+
+	settings := &testConfig{}
+	err := DecodeConfig(config, settings)
+	if err != nil {
+		t.Fatalf("Failed to decode config: %v", err)
+	}
+
+	if settings.Name != "server" || settings.Port != 8080 || settings.Mode != "fast" || !settings.Enable {
+		t.Errorf("Unexpected values: %+v", settings)
+	}
+
+	if settings.Interval != 5*time.Second || settings.SetupTimeout != 30 || settings.Limits.Size != 10 {
+		t.Errorf("Defaults were not applied: %+v", settings)
+	}
+
+	if len(settings.Tags) != 2 || settings.Labels["zone"] != "eu" {
+		t.Errorf("Collections were not decoded: %+v", settings)
+	}
+}
+
+func TestDecodeConfigListsAllProblems(t *testing.T) {
+	config := NewEmptyConfig("goal.test")
+	config.Set("port", 0)
+	config.Set("mode", "medium")
+	config.Set("enable", []interface{}{})
+	config.Set("nmae", "typo")
+
+	err := DecodeConfig(config, &testConfig{})
+	if err == nil {
+		t.Fatal("Invalid config was decoded")
+	}
+
+	problems := err.(*GoalConfigError).Problems
+	expected := []string{
+		"goal.test.name: required value is missing",
+		"goal.test.port: must be at least 1",
+		"goal.test.mode: must be one of fast, slow",
+		"goal.test.enable: expected bool",
+		"goal.test.nmae: unknown key",
+	}
+
+	if len(problems) != len(expected) {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+
+	for i, problem := range problems {
+		if !strings.HasPrefix(problem, expected[i]) {
+			t.Errorf("Unexpected problem: %s (expected: %s)", problem, expected[i])
+		}
+	}
+}
+
+type portConfig struct {
+	Port int `config:"port" default:"8080"`
+}
+
+func TestValidateConfig(t *testing.T) {
+	RegisterConfigSchema("schematest", portConfig{})
+
+	config := NewEmptyConfig("goal")
+	config.Set("goal.schematest.port", 80)
+
+	err := ValidateConfig(config)
+	if err != nil {
+		t.Errorf("Valid config was rejected: %v", err)
+	}
+
+	config.Set("goal.schematest.port", "http")
+
+	err = ValidateConfig(config)
+	if err == nil || !strings.Contains(err.Error(), "goal.schematest.port: expected int") {
+		t.Errorf("Invalid config was not rejected: %v", err)
+	}
+
+	config = NewEmptyConfig("goal")
+	config.Set("goal.schematset.port", 80)
+
+	err = ValidateConfig(config)
+	if err == nil || !strings.Contains(err.Error(), "goal.schematset: unknown key") {
+		t.Errorf("Config without a schema was not rejected: %v", err)
+	}
+}
+
+type tokenConfig struct {
+	Token int `config:"token" secret:"true"`
+}
+
+func TestValidateConfigRedactsSecrets(t *testing.T) {
+	RegisterConfigSchema("secretschematest", tokenConfig{})
+
+	config := NewEmptyConfig("goal")
+	config.Set("goal.secretschematest.token", "hunter2")
+
+	err := ValidateConfig(config)
+	if err == nil || !strings.Contains(err.Error(), "goal.secretschematest.token: invalid value") {
+		t.Fatalf("Invalid secret was not rejected: %v", err)
+	}
+
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("Secret leaked into the error: %v", err)
+	}
+}
@@ -0,0 +1,298 @@
+package api
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+// GoalSecretProvider resolves the argument of a ${provider:argument}
+// reference found in a configuration value
+type GoalSecretProvider func(argument string) (string, error)
+
+var secretProviders = map[string]GoalSecretProvider{
+	"file": readFileSecret,
+	"env":  readEnvSecret,
+	"enc":  decryptSecret,
+}
+
+var secretReference = regexp.MustCompile(`^\$\{(\w+):(.*)\}$`)
+
+// secretValue marks the leaves of a configuration which were obtained by
+// resolving a secret reference, so they are redacted wherever that
+// configuration is printed or logged
+type secretValue string
+
+// RegisterSecretProvider adds a provider which will be used to resolve
+// ${name:argument} references in configuration values
+func RegisterSecretProvider(name string, provider GoalSecretProvider) {
+	secretProviders[name] = provider
+}
+
+// IsSecretValue returns true if the given configuration leaf was
+// obtained by resolving a secret reference
+func IsSecretValue(value interface{}) bool {
+	_, ok := value.(secretValue)
+
+	return ok
+}
+
+// RedactConfig returns a copy of a (sub)config's data in which
+// secret values are replaced by RedactedConfigValue
+func RedactConfig(config *GoalConfig) map[string]interface{} {
+	leaves := map[string]interface{}{}
+
+	for path, value := range FlattenConfig("", config.Data()) {
+		if IsSecretConfig(config.Name() + "." + path) {
+			value = RedactedConfigValue
+		}
+
+		leaves[path] = RedactSecrets(value)
+	}
+
+	return UnflattenConfig(leaves)
+}
+
+// RedactSecrets replaces secret values found in configurations, maps
+// and lists taken from configurations by RedactedConfigValue
+func RedactSecrets(value interface{}) interface{} {
+	switch value := value.(type) {
+	case *GoalConfig:
+		return RedactConfig(value)
+	case secretValue:
+		return RedactedConfigValue
+	case map[string]interface{}:
+		redacted := map[string]interface{}{}
+		for key, val := range value {
+			redacted[key] = RedactSecrets(val)
+		}
+
+		return redacted
+	case map[interface{}]interface{}:
+		redacted := map[interface{}]interface{}{}
+		for key, val := range value {
+			redacted[key] = RedactSecrets(val)
+		}
+
+		return redacted
+	case []interface{}:
+		redacted := []interface{}{}
+		for _, val := range value {
+			redacted = append(redacted, RedactSecrets(val))
+		}
+
+		return redacted
+	}
+
+	return value
+}
+
+// EncryptSecret encrypts a value with the local secret key, and returns
+// a reference which can be used as a configuration value
+func EncryptSecret(value string) (string, error) {
+	gcm, err := getSecretCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", errors.Wrap(err, 0)
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+
+	return fmt.Sprintf("${enc:%s}", base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// GenerateSecretKey creates a new random key to be used to encrypt secrets
+func GenerateSecretKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, key)
+	if err != nil {
+		return "", errors.Wrap(err, 0)
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// resolveSecrets replaces all secret references found in a configuration,
+// including those found in lists
+func resolveSecrets(target *GoalConfig) error {
+	problems := []string{}
+
+	for path, value := range FlattenConfig("", target.Data()) {
+		resolved, changed, valueProblems := resolveValueSecrets(joinConfigPath(target.Name(), path), value)
+		problems = append(problems, valueProblems...)
+
+		if !changed {
+			continue
+		}
+
+		err := target.Set(path, resolved)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", joinConfigPath(target.Name(), path), err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &GoalConfigError{Problems: problems}
+	}
+
+	return nil
+}
+
+// resolveValueSecrets resolves the secret references of a leaf, recursing
+// into lists and the maps they hold; resolved lists and maps are copies
+func resolveValueSecrets(path string, value interface{}) (interface{}, bool, []string) {
+	switch value := value.(type) {
+	case []interface{}:
+		resolved := []interface{}{}
+		changed := false
+		problems := []string{}
+
+		for i, item := range value {
+			item, itemChanged, itemProblems := resolveValueSecrets(fmt.Sprintf("%s[%d]", path, i), item)
+			resolved = append(resolved, item)
+			changed = changed || itemChanged
+			problems = append(problems, itemProblems...)
+		}
+
+		return resolved, changed, problems
+	case map[string]interface{}, map[interface{}]interface{}:
+		entries, _ := toStringMap(value)
+		resolved := map[string]interface{}{}
+		changed := false
+		problems := []string{}
+
+		for key, entry := range entries {
+			entry, entryChanged, entryProblems := resolveValueSecrets(joinConfigPath(path, key), entry)
+			resolved[key] = entry
+			changed = changed || entryChanged
+			problems = append(problems, entryProblems...)
+		}
+
+		return resolved, changed, problems
+	}
+
+	resolved, isSecret, err := resolveSecret(value)
+	if err != nil {
+		return value, false, []string{fmt.Sprintf("%s: %v", path, err)}
+	}
+
+	if !isSecret {
+		return value, false, nil
+	}
+
+	return secretValue(resolved), true, nil
+}
+
+func resolveSecret(value interface{}) (string, bool, error) {
+	str, ok := value.(string)
+	if !ok {
+		return "", false, nil
+	}
+
+	matches := secretReference.FindStringSubmatch(str)
+	if matches == nil {
+		return "", false, nil
+	}
+
+	provider, found := secretProviders[matches[1]]
+	if !found {
+		return "", false, errors.Errorf("unknown secret provider %s", matches[1])
+	}
+
+	resolved, err := provider(matches[2])
+	if err != nil {
+		return "", false, errors.Errorf("could not resolve %s secret: %v", matches[1], err)
+	}
+
+	return resolved, true, nil
+}
+
+func readFileSecret(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func readEnvSecret(name string) (string, error) {
+	value, found := os.LookupEnv(name)
+	if !found {
+		return "", errors.Errorf("environment variable %s is not set", name)
+	}
+
+	return value, nil
+}
+
+func decryptSecret(encoded string) (string, error) {
+	gcm, err := getSecretCipher()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+
+	nonce := sealed[:gcm.NonceSize()]
+	value, err := gcm.Open(nil, nonce, sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(value), nil
+}
+
+// getSecretCipher loads the key found in the file pointed by GOAL_SECRET_KEY_FILE,
+// or in secret.key in the configuration folder
+func getSecretCipher() (cipher.AEAD, error) {
+	keyFile := os.Getenv("GOAL_SECRET_KEY_FILE")
+	if keyFile == "" {
+		keyFile = fmt.Sprintf("%s/secret.key", getConfigPath())
+	}
+
+	encodedKey, err := readFileSecret(keyFile)
+	if err != nil {
+		return nil, errors.Errorf("could not read secret key: %v", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+	if err != nil {
+		return nil, errors.Errorf("invalid secret key: %v", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Errorf("invalid secret key: %v", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func joinConfigPath(prefix string, path string) string {
+	if prefix == "" {
+		return path
+	}
+
+	return prefix + "." + path
+}
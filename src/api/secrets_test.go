@@ -0,0 +1,105 @@
+package api_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/Wizcorp/goal/src/api"
+)
+
+func TestSecretReferences(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "db")
+	keyFile := filepath.Join(dir, "secret.key")
+
+	key, err := GenerateSecretKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	ioutil.WriteFile(secretFile, []byte("from-file\n"), 0600)
+	ioutil.WriteFile(keyFile, []byte(key), 0600)
+
+	t.Setenv("GOAL_SECRET_KEY_FILE", keyFile)
+	t.Setenv("SECRETS_TEST_PASSWORD", "from-env")
+
+	encrypted, err := EncryptSecret("from-key")
+	if err != nil {
+		t.Fatalf("Failed to encrypt secret: %v", err)
+	}
+
+	config := NewEmptyConfig("goal")
+	config.Set("goal.secrets.file", "${file:"+secretFile+"}")
+	config.Set("goal.secrets.env", "${env:SECRETS_TEST_PASSWORD}")
+	config.Set("goal.secrets.encrypted", encrypted)
+	config.Set("goal.secrets.plain", "visible")
+	config.Set("goal.secrets.same", "from-env")
+
+	subconfig, err := GetSubconfig("secrets", config)
+	if err != nil {
+		t.Fatalf("Failed to resolve secrets: %v", err)
+	}
+
+	expected := map[string]string{
+		"file":      "from-file",
+		"env":       "from-env",
+		"encrypted": "from-key",
+		"plain":     "visible",
+		"same":      "from-env",
+	}
+
+	for key, value := range expected {
+		if subconfig.String(key) != value {
+			t.Errorf("Unexpected value for %s: %s != %s", key, subconfig.String(key), value)
+		}
+	}
+
+	redacted := RedactConfig(subconfig)
+	if redacted["env"] != RedactedConfigValue || redacted["plain"] != "visible" || redacted["same"] != "from-env" {
+		t.Errorf("Secrets were not redacted: %v", redacted)
+	}
+}
+
+func TestSecretReferencesInLists(t *testing.T) {
+	t.Setenv("SECRETS_TEST_PASSWORD", "from-env")
+
+	config := NewEmptyConfig("goal")
+	config.Set("goal.secrets.list", []interface{}{
+		"visible",
+		"${env:SECRETS_TEST_PASSWORD}",
+		map[string]interface{}{"password": "${env:SECRETS_TEST_PASSWORD}"},
+	})
+
+	subconfig, err := GetSubconfig("secrets", config)
+	if err != nil {
+		t.Fatalf("Failed to resolve secrets: %v", err)
+	}
+
+	list := subconfig.Get("list").([]interface{})
+	if list[0] != "visible" || !IsSecretValue(list[1]) || fmt.Sprint(list[1]) != "from-env" {
+		t.Errorf("Secret in list was not resolved: %v", list)
+	}
+
+	if entry := list[2].(map[string]interface{}); !IsSecretValue(entry["password"]) {
+		t.Errorf("Secret in list entry was not resolved: %v", entry)
+	}
+
+	redacted := fmt.Sprint(RedactConfig(subconfig)["list"])
+	if strings.Contains(redacted, "from-env") || !strings.Contains(redacted, "visible") {
+		t.Errorf("Secrets in list were not redacted: %v", redacted)
+	}
+}
+
+func TestUnresolvableSecretReferences(t *testing.T) {
+	config := NewEmptyConfig("goal")
+	config.Set("goal.secrets.env", "${env:SECRETS_TEST_MISSING}")
+	config.Set("goal.secrets.unknown", "${vault:path}")
+
+	_, err := GetSubconfig("secrets", config)
+	if err == nil {
+		t.Error("Unresolvable secrets were accepted")
+	}
+}
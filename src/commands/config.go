@@ -0,0 +1,165 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	. "github.com/Wizcorp/goal/src/api"
+)
+
+type configLeaf struct {
+	Value  interface{} `json:"value" yaml:"value"`
+	Source string      `json:"source" yaml:"source"`
+}
+
+func init() {
+	var format string
+	var showSources bool
+
+	command := &Command{
+		Use:   "config [system]",
+		Args:  cobra.MaximumNArgs(1),
+		Short: "Print the effective configuration",
+		Long: `Print the effective configuration, once all configuration files,
+environment variables and schema defaults have been merged.
+
+Secret values are redacted.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			prefix := "goal"
+			if len(args) > 0 {
+				prefix = fmt.Sprintf("goal.%s", args[0])
+			}
+
+			config, sources, err := BuildConfig()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+				os.Exit(1)
+			}
+
+			leaves := getEffectiveConfig(config, sources, prefix)
+
+			var output interface{}
+			if showSources {
+				output = leaves
+			} else {
+				output = unflattenConfig(leaves)
+			}
+
+			err = printConfig(output, format)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to print configuration: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	command.Flags().StringVarP(&format, "format", "f", "yaml", "Output format (yaml or json)")
+	command.Flags().BoolVarP(&showSources, "sources", "s", false, "Show which layer supplied each value")
+
+	RegisterCommand(command)
+}
+
+func getEffectiveConfig(config *GoalConfig, sources GoalConfigSources, prefix string) map[string]configLeaf {
+	leaves := map[string]configLeaf{}
+
+	for path, value := range GetConfigDefaults() {
+		leaves[path] = configLeaf{Value: value, Source: "default"}
+	}
+
+	for path, value := range FlattenConfig("goal", config.Get("goal")) {
+		source, found := sources[path]
+		if !found {
+			source = "unknown"
+		}
+
+		leaves[path] = configLeaf{Value: value, Source: source}
+	}
+
+	for path, leaf := range leaves {
+		if path != prefix && !strings.HasPrefix(path, prefix+".") {
+			delete(leaves, path)
+			continue
+		}
+
+		if IsSecretConfig(path) || IsSecretValue(leaf.Value) {
+			leaf.Value = RedactedConfigValue
+			leaves[path] = leaf
+		}
+	}
+
+	return leaves
+}
+
+func unflattenConfig(leaves map[string]configLeaf) map[string]interface{} {
+	values := map[string]interface{}{}
+	for path, leaf := range leaves {
+		values[path] = normalizeConfigValue(leaf.Value)
+	}
+
+	return UnflattenConfig(values)
+}
+
+// normalizeConfigValue converts maps decoded from YAML files so they
+// can be encoded in JSON
+func normalizeConfigValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		normalized := map[string]interface{}{}
+		for key, val := range value {
+			normalized[fmt.Sprint(key)] = normalizeConfigValue(val)
+		}
+
+		return normalized
+	case map[string]interface{}:
+		normalized := map[string]interface{}{}
+		for key, val := range value {
+			normalized[key] = normalizeConfigValue(val)
+		}
+
+		return normalized
+	case []interface{}:
+		normalized := []interface{}{}
+		for _, val := range value {
+			normalized = append(normalized, normalizeConfigValue(val))
+		}
+
+		return normalized
+	}
+
+	return value
+}
+
+func printConfig(output interface{}, format string) error {
+	if leaves, ok := output.(map[string]configLeaf); ok {
+		for path, leaf := range leaves {
+			leaf.Value = normalizeConfigValue(leaf.Value)
+			leaves[path] = leaf
+		}
+	}
+
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(data))
+	case "yaml":
+		data, err := yaml.Marshal(output)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(string(data))
+	default:
+		return fmt.Errorf("unknown format %s", format)
+	}
+
+	return nil
+}
@@ -152,12 +152,12 @@ func addTemplateFiles(src string, dest string) (error, *string) {
 }
 
 type TaskfileVars struct {
-	Pkg     string `yaml:pkg`
-	Version string `yaml:version`
+	Pkg     string `yaml:"pkg"`
+	Version string `yaml:"version"`
 }
 
 type Taskfile struct {
-	Vars TaskfileVars `yaml:vars`
+	Vars TaskfileVars `yaml:"vars"`
 }
 
 func runCommand(cmd *exec.Cmd) (error, *string) {
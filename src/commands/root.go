@@ -24,9 +24,13 @@ var command = &cobra.Command{
 	Short: "Goal NextGen Game Server",
 	Long:  `GoalNG is a game server framework for real-time games`,
 	Run: func(cmd *cobra.Command, args []string) {
-		config := LoadConfig()
+		config, _, err := BuildConfig()
+		if err != nil {
+			log.Fatalf("Failed to load the configuration:\n\n %v", err)
+		}
+
 		server := systems.NewServer(config)
-		err := server.Start()
+		err = server.Start()
 
 		if err != nil {
 			log.Fatalf("Failed to start the server:\n\n %s", errors.Wrap(err, 0).ErrorStack())
@@ -57,12 +61,31 @@ var command = &cobra.Command{
 			}
 		}
 
+		reload := func(s os.Signal) {
+			logger.Infof("Received signal %v, reloading configuration", s)
+			config, _, err := BuildConfig()
+
+			if err != nil {
+				logger.WithFields(LogFields{
+					"error": err,
+				}).Error("Failed to reload configuration, keeping the current one")
+				return
+			}
+
+			err = server.Reconfigure(config)
+			if err != nil {
+				logger.WithFields(LogFields{
+					"error": err,
+				}).Error("Failed to apply reloaded configuration")
+			}
+		}
+
 		handleSignal := func(interrupt chan os.Signal, config *GoalConfig) {
 			for {
 				s := <-interrupt
 				switch s {
 				case syscall.SIGHUP:
-					shutdown(s)
+					reload(s)
 				case syscall.SIGINT:
 					shutdown(s)
 				case syscall.SIGTERM:
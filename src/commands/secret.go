@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	. "github.com/Wizcorp/goal/src/api"
+)
+
+func init() {
+	command := &Command{
+		Use:   "secret",
+		Short: "Manage encrypted configuration values",
+		Long: `Manage encrypted configuration values.
+
+Encrypted values use the key found in the file pointed by GOAL_SECRET_KEY_FILE,
+or in secret.key in the configuration folder.`,
+	}
+
+	command.AddCommand(&Command{
+		Use:   "generate-key",
+		Args:  cobra.NoArgs,
+		Short: "Generate a new key to encrypt configuration values",
+		Long:  `Generate a new key to encrypt configuration values`,
+		Run: func(cmd *cobra.Command, args []string) {
+			key, err := GenerateSecretKey()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to generate key: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Println(key)
+		},
+	})
+
+	command.AddCommand(&Command{
+		Use:   "encrypt [value]",
+		Args:  cobra.ExactArgs(1),
+		Short: "Encrypt a value to be used in configuration files",
+		Long:  `Encrypt a value to be used in configuration files`,
+		Run: func(cmd *cobra.Command, args []string) {
+			reference, err := EncryptSecret(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to encrypt value: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Println(reference)
+		},
+	})
+
+	RegisterCommand(command)
+}
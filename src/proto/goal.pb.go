@@ -201,31 +201,131 @@ func (m *GoalPingResponse) GetTimestamp() int64 {
 	return 0
 }
 
+type GoalClusterMessage struct {
+	Room                 string     `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+	Session              string     `protobuf:"bytes,2,opt,name=session,proto3" json:"session,omitempty"`
+	Messages             []*any.Any `protobuf:"bytes,3,rep,name=messages,proto3" json:"messages,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *GoalClusterMessage) Reset()         { *m = GoalClusterMessage{} }
+func (m *GoalClusterMessage) String() string { return proto.CompactTextString(m) }
+func (*GoalClusterMessage) ProtoMessage()    {}
+func (*GoalClusterMessage) Descriptor() ([]byte, []int) {
+	return fileDescriptor_5923fbff71b92efe, []int{4}
+}
+
+func (m *GoalClusterMessage) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GoalClusterMessage.Unmarshal(m, b)
+}
+func (m *GoalClusterMessage) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GoalClusterMessage.Marshal(b, m, deterministic)
+}
+func (m *GoalClusterMessage) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GoalClusterMessage.Merge(m, src)
+}
+func (m *GoalClusterMessage) XXX_Size() int {
+	return xxx_messageInfo_GoalClusterMessage.Size(m)
+}
+func (m *GoalClusterMessage) XXX_DiscardUnknown() {
+	xxx_messageInfo_GoalClusterMessage.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GoalClusterMessage proto.InternalMessageInfo
+
+func (m *GoalClusterMessage) GetRoom() string {
+	if m != nil {
+		return m.Room
+	}
+	return ""
+}
+
+func (m *GoalClusterMessage) GetSession() string {
+	if m != nil {
+		return m.Session
+	}
+	return ""
+}
+
+func (m *GoalClusterMessage) GetMessages() []*any.Any {
+	if m != nil {
+		return m.Messages
+	}
+	return nil
+}
+
+type GoalClusterDelivery struct {
+	Delivered            bool     `protobuf:"varint,1,opt,name=delivered,proto3" json:"delivered,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GoalClusterDelivery) Reset()         { *m = GoalClusterDelivery{} }
+func (m *GoalClusterDelivery) String() string { return proto.CompactTextString(m) }
+func (*GoalClusterDelivery) ProtoMessage()    {}
+func (*GoalClusterDelivery) Descriptor() ([]byte, []int) {
+	return fileDescriptor_5923fbff71b92efe, []int{5}
+}
+
+func (m *GoalClusterDelivery) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GoalClusterDelivery.Unmarshal(m, b)
+}
+func (m *GoalClusterDelivery) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GoalClusterDelivery.Marshal(b, m, deterministic)
+}
+func (m *GoalClusterDelivery) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GoalClusterDelivery.Merge(m, src)
+}
+func (m *GoalClusterDelivery) XXX_Size() int {
+	return xxx_messageInfo_GoalClusterDelivery.Size(m)
+}
+func (m *GoalClusterDelivery) XXX_DiscardUnknown() {
+	xxx_messageInfo_GoalClusterDelivery.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GoalClusterDelivery proto.InternalMessageInfo
+
+func (m *GoalClusterDelivery) GetDelivered() bool {
+	if m != nil {
+		return m.Delivered
+	}
+	return false
+}
+
 func init() {
 	proto.RegisterType((*GoalMessageEnvelope)(nil), "proto.GoalMessageEnvelope")
 	proto.RegisterType((*GoalError)(nil), "proto.GoalError")
 	proto.RegisterType((*GoalPingRequest)(nil), "proto.GoalPingRequest")
 	proto.RegisterType((*GoalPingResponse)(nil), "proto.GoalPingResponse")
+	proto.RegisterType((*GoalClusterMessage)(nil), "proto.GoalClusterMessage")
+	proto.RegisterType((*GoalClusterDelivery)(nil), "proto.GoalClusterDelivery")
 }
 
 func init() { proto.RegisterFile("src/proto/goal.proto", fileDescriptor_5923fbff71b92efe) }
 
 var fileDescriptor_5923fbff71b92efe = []byte{
-	// 251 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x8e, 0xc1, 0x4b, 0xc3, 0x30,
-	0x14, 0xc6, 0x59, 0xbb, 0xa9, 0x7d, 0x82, 0x4a, 0x1c, 0x5a, 0x87, 0x87, 0xd2, 0x53, 0x4f, 0xe9,
-	0x98, 0x07, 0x8f, 0xe2, 0x61, 0x78, 0x12, 0xa4, 0x17, 0x8f, 0x92, 0xad, 0xcf, 0x10, 0x48, 0xf3,
-	0x6a, 0x92, 0x09, 0xfb, 0xef, 0xa5, 0x89, 0x73, 0xe0, 0x60, 0xa7, 0xf7, 0xf8, 0xf2, 0x7b, 0xbf,
-	0x2f, 0x30, 0x75, 0x76, 0x5d, 0xf7, 0x96, 0x3c, 0xd5, 0x92, 0x84, 0xe6, 0x61, 0x65, 0x93, 0x30,
-	0x66, 0x77, 0x92, 0x48, 0x6a, 0x8c, 0xef, 0xab, 0xcd, 0x67, 0x2d, 0xcc, 0x36, 0x12, 0xe5, 0x3b,
-	0x5c, 0xbf, 0x90, 0xd0, 0xaf, 0xe8, 0x9c, 0x90, 0xb8, 0x34, 0xdf, 0xa8, 0xa9, 0x47, 0x76, 0x01,
-	0x89, 0x6a, 0xf3, 0x51, 0x31, 0xaa, 0x26, 0x4d, 0xa2, 0x5a, 0x36, 0x87, 0xb3, 0x2e, 0x22, 0x2e,
-	0x4f, 0x8a, 0xb4, 0x3a, 0x5f, 0x4c, 0x79, 0x94, 0xf2, 0x9d, 0x94, 0x3f, 0x9b, 0x6d, 0xf3, 0x47,
-	0x95, 0x1f, 0x90, 0x0d, 0xe2, 0xa5, 0xb5, 0x64, 0x0f, 0x74, 0x0c, 0xc6, 0x6b, 0x6a, 0x31, 0x4f,
-	0x8a, 0x51, 0x95, 0x35, 0x61, 0x67, 0x1c, 0x4e, 0x5b, 0xf4, 0x42, 0x69, 0x97, 0xa7, 0x47, 0x1a,
-	0x76, 0x50, 0x59, 0xc3, 0xe5, 0x50, 0xf0, 0xa6, 0x8c, 0x6c, 0xf0, 0x6b, 0x83, 0xce, 0xb3, 0x7b,
-	0xc8, 0xbc, 0xea, 0xd0, 0x79, 0xd1, 0xf5, 0xa1, 0x2d, 0x6d, 0xf6, 0x41, 0x39, 0x87, 0xab, 0xfd,
-	0x81, 0xeb, 0xc9, 0x38, 0x3c, 0x7e, 0xb1, 0x78, 0x82, 0xf1, 0x40, 0xb3, 0xc7, 0xdf, 0x79, 0x13,
-	0xbf, 0xc2, 0xff, 0xf5, 0xce, 0x6e, 0x0f, 0xf2, 0xa8, 0x5f, 0x9d, 0x84, 0xfc, 0xe1, 0x27, 0x00,
-	0x00, 0xff, 0xff, 0x79, 0xe0, 0x04, 0x25, 0x9e, 0x01, 0x00, 0x00,
+	// 311 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x50, 0x41, 0x4f, 0xea, 0x40,
+	0x10, 0x0e, 0x2d, 0x3c, 0xe8, 0xbc, 0xe4, 0xbd, 0x97, 0x85, 0x3c, 0x2b, 0xf1, 0x40, 0x7a, 0xe2,
+	0xd4, 0x12, 0x38, 0x78, 0x34, 0x46, 0x89, 0x27, 0x13, 0xb3, 0x17, 0x8f, 0xa6, 0xd0, 0xb1, 0xd9,
+	0x64, 0xdb, 0xa9, 0x3b, 0x0b, 0x09, 0xff, 0xde, 0x74, 0x17, 0x44, 0x25, 0x51, 0x4f, 0xfb, 0xf5,
+	0xeb, 0x37, 0xdf, 0x37, 0xf3, 0xc1, 0x88, 0xcd, 0x3a, 0x6b, 0x0c, 0x59, 0xca, 0x4a, 0xca, 0x75,
+	0xea, 0xa0, 0xe8, 0xb9, 0x67, 0x7c, 0x5e, 0x12, 0x95, 0x1a, 0xfd, 0xff, 0xd5, 0xe6, 0x39, 0xcb,
+	0xeb, 0x9d, 0x57, 0x24, 0x8f, 0x30, 0xbc, 0xa3, 0x5c, 0xdf, 0x23, 0x73, 0x5e, 0xe2, 0xb2, 0xde,
+	0xa2, 0xa6, 0x06, 0xc5, 0x1f, 0x08, 0x54, 0x11, 0x77, 0x26, 0x9d, 0x69, 0x4f, 0x06, 0xaa, 0x10,
+	0x33, 0x18, 0x54, 0x5e, 0xc2, 0x71, 0x30, 0x09, 0xa7, 0xbf, 0xe7, 0xa3, 0xd4, 0x9b, 0xa6, 0x07,
+	0xd3, 0xf4, 0xba, 0xde, 0xc9, 0x37, 0x55, 0xf2, 0x04, 0x51, 0x6b, 0xbc, 0x34, 0x86, 0xcc, 0x89,
+	0x9d, 0x80, 0xee, 0x9a, 0x0a, 0x8c, 0x83, 0x49, 0x67, 0x1a, 0x49, 0x87, 0x45, 0x0a, 0xfd, 0x02,
+	0x6d, 0xae, 0x34, 0xc7, 0xe1, 0x17, 0x09, 0x07, 0x51, 0x92, 0xc1, 0xdf, 0x36, 0xe0, 0x41, 0xd5,
+	0xa5, 0xc4, 0x97, 0x0d, 0xb2, 0x15, 0x17, 0x10, 0x59, 0x55, 0x21, 0xdb, 0xbc, 0x6a, 0x5c, 0x5a,
+	0x28, 0x8f, 0x44, 0x32, 0x83, 0x7f, 0xc7, 0x01, 0x6e, 0xa8, 0x66, 0xfc, 0x66, 0xc2, 0x82, 0x68,
+	0x27, 0x6e, 0xf4, 0x86, 0x2d, 0x9a, 0x7d, 0x47, 0xed, 0xf2, 0x86, 0xa8, 0x72, 0xf2, 0x48, 0x3a,
+	0x2c, 0x62, 0xe8, 0x33, 0x32, 0x2b, 0xaa, 0xf7, 0x37, 0x1d, 0x3e, 0x3f, 0x34, 0x17, 0xfe, 0xa8,
+	0xb9, 0x05, 0x0c, 0xdf, 0xa5, 0xde, 0xa2, 0x56, 0x5b, 0x34, 0xbb, 0x76, 0xd5, 0xc2, 0x63, 0xf4,
+	0x55, 0x0e, 0xe4, 0x91, 0x98, 0x5f, 0x41, 0xb7, 0x3d, 0x4c, 0x5c, 0xee, 0xdf, 0xff, 0xde, 0x3d,
+	0xfd, 0x54, 0xd1, 0xf8, 0xec, 0x84, 0xf7, 0x4d, 0xac, 0x7e, 0x39, 0x7e, 0xf1, 0x3a, 0x00, 0x11,
+	0xa9, 0x07, 0x87, 0x49, 0x02, 0x00, 0x00,
 }
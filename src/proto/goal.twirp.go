@@ -748,21 +748,24 @@ func callError(ctx context.Context, h *twirp.ServerHooks, err twirp.Error) conte
 }
 
 var twirpFileDescriptor0 = []byte{
-	// 251 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x8e, 0xc1, 0x4b, 0xc3, 0x30,
-	0x14, 0xc6, 0x59, 0xbb, 0xa9, 0x7d, 0x82, 0x4a, 0x1c, 0x5a, 0x87, 0x87, 0xd2, 0x53, 0x4f, 0xe9,
-	0x98, 0x07, 0x8f, 0xe2, 0x61, 0x78, 0x12, 0xa4, 0x17, 0x8f, 0x92, 0xad, 0xcf, 0x10, 0x48, 0xf3,
-	0x6a, 0x92, 0x09, 0xfb, 0xef, 0xa5, 0x89, 0x73, 0xe0, 0x60, 0xa7, 0xf7, 0xf8, 0xf2, 0x7b, 0xbf,
-	0x2f, 0x30, 0x75, 0x76, 0x5d, 0xf7, 0x96, 0x3c, 0xd5, 0x92, 0x84, 0xe6, 0x61, 0x65, 0x93, 0x30,
-	0x66, 0x77, 0x92, 0x48, 0x6a, 0x8c, 0xef, 0xab, 0xcd, 0x67, 0x2d, 0xcc, 0x36, 0x12, 0xe5, 0x3b,
-	0x5c, 0xbf, 0x90, 0xd0, 0xaf, 0xe8, 0x9c, 0x90, 0xb8, 0x34, 0xdf, 0xa8, 0xa9, 0x47, 0x76, 0x01,
-	0x89, 0x6a, 0xf3, 0x51, 0x31, 0xaa, 0x26, 0x4d, 0xa2, 0x5a, 0x36, 0x87, 0xb3, 0x2e, 0x22, 0x2e,
-	0x4f, 0x8a, 0xb4, 0x3a, 0x5f, 0x4c, 0x79, 0x94, 0xf2, 0x9d, 0x94, 0x3f, 0x9b, 0x6d, 0xf3, 0x47,
-	0x95, 0x1f, 0x90, 0x0d, 0xe2, 0xa5, 0xb5, 0x64, 0x0f, 0x74, 0x0c, 0xc6, 0x6b, 0x6a, 0x31, 0x4f,
-	0x8a, 0x51, 0x95, 0x35, 0x61, 0x67, 0x1c, 0x4e, 0x5b, 0xf4, 0x42, 0x69, 0x97, 0xa7, 0x47, 0x1a,
-	0x76, 0x50, 0x59, 0xc3, 0xe5, 0x50, 0xf0, 0xa6, 0x8c, 0x6c, 0xf0, 0x6b, 0x83, 0xce, 0xb3, 0x7b,
-	0xc8, 0xbc, 0xea, 0xd0, 0x79, 0xd1, 0xf5, 0xa1, 0x2d, 0x6d, 0xf6, 0x41, 0x39, 0x87, 0xab, 0xfd,
-	0x81, 0xeb, 0xc9, 0x38, 0x3c, 0x7e, 0xb1, 0x78, 0x82, 0xf1, 0x40, 0xb3, 0xc7, 0xdf, 0x79, 0x13,
-	0xbf, 0xc2, 0xff, 0xf5, 0xce, 0x6e, 0x0f, 0xf2, 0xa8, 0x5f, 0x9d, 0x84, 0xfc, 0xe1, 0x27, 0x00,
-	0x00, 0xff, 0xff, 0x79, 0xe0, 0x04, 0x25, 0x9e, 0x01, 0x00, 0x00,
+	// 289 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x90, 0xc1, 0x4b, 0xeb, 0x40,
+	0x10, 0xc6, 0x69, 0xd2, 0xbe, 0xbe, 0x8c, 0xa0, 0xb2, 0x16, 0x8d, 0xc5, 0x43, 0xc9, 0xa9, 0xa7,
+	0xa4, 0xd4, 0x83, 0x47, 0x11, 0x29, 0x9e, 0x04, 0xc9, 0xc5, 0xa3, 0x6c, 0x9b, 0x31, 0x2c, 0x6c,
+	0x76, 0xe2, 0xce, 0x56, 0xe8, 0x7f, 0x2f, 0xd9, 0x6d, 0x2d, 0x5a, 0x50, 0x4f, 0x33, 0x3b, 0xfb,
+	0x9b, 0x6f, 0x66, 0x3e, 0x18, 0xb1, 0x5d, 0x15, 0xad, 0x25, 0x47, 0x45, 0x4d, 0x52, 0xe7, 0x3e,
+	0x15, 0x03, 0x1f, 0xc6, 0x97, 0x35, 0x51, 0xad, 0x31, 0xfc, 0x2f, 0xd7, 0xaf, 0x85, 0x34, 0x9b,
+	0x40, 0x64, 0xcf, 0x70, 0xf6, 0x40, 0x52, 0x3f, 0x22, 0xb3, 0xac, 0x71, 0x61, 0xde, 0x51, 0x53,
+	0x8b, 0xe2, 0x18, 0x22, 0x55, 0xa5, 0xbd, 0x49, 0x6f, 0x3a, 0x28, 0x23, 0x55, 0x89, 0x19, 0xfc,
+	0x6f, 0x02, 0xc2, 0x69, 0x34, 0x89, 0xa7, 0x47, 0xf3, 0x51, 0x1e, 0x44, 0xf3, 0x9d, 0x68, 0x7e,
+	0x67, 0x36, 0xe5, 0x27, 0x95, 0xbd, 0x40, 0xd2, 0x09, 0x2f, 0xac, 0x25, 0x7b, 0x20, 0x27, 0xa0,
+	0xbf, 0xa2, 0x0a, 0xd3, 0x68, 0xd2, 0x9b, 0x26, 0xa5, 0xcf, 0x45, 0x0e, 0xc3, 0x0a, 0x9d, 0x54,
+	0x9a, 0xd3, 0xf8, 0x87, 0x09, 0x3b, 0x28, 0x2b, 0xe0, 0xa4, 0x1b, 0xf0, 0xa4, 0x4c, 0x5d, 0xe2,
+	0xdb, 0x1a, 0xd9, 0x89, 0x2b, 0x48, 0x9c, 0x6a, 0x90, 0x9d, 0x6c, 0x5a, 0x3f, 0x2d, 0x2e, 0xf7,
+	0x85, 0x6c, 0x06, 0xa7, 0xfb, 0x06, 0x6e, 0xc9, 0x30, 0xfe, 0xd2, 0xe1, 0x40, 0x74, 0x1d, 0xf7,
+	0x7a, 0xcd, 0x0e, 0xed, 0xd6, 0xa3, 0x6e, 0x79, 0x4b, 0xd4, 0x78, 0x3c, 0x29, 0x7d, 0x2e, 0x52,
+	0x18, 0x32, 0x32, 0x2b, 0x32, 0xdb, 0x9b, 0x76, 0xcf, 0x2f, 0xce, 0xc5, 0x7f, 0x71, 0x6e, 0x7e,
+	0x0b, 0xfd, 0x6e, 0x47, 0x71, 0xb3, 0x8d, 0xe7, 0x01, 0xcc, 0xbf, 0x5d, 0x3b, 0xbe, 0x38, 0xa8,
+	0x87, 0xa3, 0x96, 0xff, 0x7c, 0xfd, 0xfa, 0x63, 0x00, 0xd3, 0x5f, 0x61, 0x99, 0x14, 0x02, 0x00,
+	0x00,
 }
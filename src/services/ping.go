@@ -2,7 +2,6 @@ package services
 
 import (
 	"context"
-	"log"
 
 	. "github.com/Wizcorp/goal/src/proto"
 	. "github.com/Wizcorp/goal/src/systems"
@@ -16,15 +15,14 @@ func (y *PingService) Ping(ctx context.Context, message *GoalPingRequest) (*Goal
 	}, nil
 }
 
-func (y *PingService) HandlePing(ctx context.Context, message *GoalPingRequest) {
-	emitter := ctx.Value("emitter").(GoalServiceEmitter)
-	err := emitter(ctx, &GoalPingResponse{
-		Timestamp: 123,
-	})
+func (y *PingService) RegisterMessageHandlers(services GoalServices) {
+	OnMessage(services, y.HandlePing)
+}
 
-	if err != nil {
-		log.Printf("%v", err)
-	}
+func (y *PingService) HandlePing(ctx context.Context, message *GoalPingRequest) error {
+	return GetSession(ctx).Emit(ctx, &GoalPingResponse{
+		Timestamp: message.Timestamp,
+	})
 }
 
 func init() {
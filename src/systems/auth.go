@@ -0,0 +1,247 @@
+package systems
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-errors/errors"
+
+	. "github.com/Wizcorp/goal/src/api"
+)
+
+func init() {
+	RegisterSystemFactory(3, "auth", func() GoalSystem {
+		return NewAuth()
+	})
+	RegisterConfigSchema("auth", authConfig{})
+}
+
+// IdentitySessionAttribute is the session attribute holding the
+// identity of authenticated sessions
+const IdentitySessionAttribute = "identity"
+
+// GoalIdentity describes who sent an authenticated request
+type GoalIdentity struct {
+	Subject string
+	Method  string
+	Claims  map[string]interface{}
+}
+
+// GoalAuthenticator extracts an identity from a request; it returns a nil
+// identity when the request carries no credentials it recognizes, and an
+// error when the credentials it found are invalid
+type GoalAuthenticator interface {
+	Authenticate(r *http.Request) (*GoalIdentity, error)
+}
+
+// GoalAuthenticatorFunc allows functions to be used as authenticators
+type GoalAuthenticatorFunc func(r *http.Request) (*GoalIdentity, error)
+
+func (authenticate GoalAuthenticatorFunc) Authenticate(r *http.Request) (*GoalIdentity, error) {
+	return authenticate(r)
+}
+
+// GoalAuth authenticates WebSocket upgrades and Twirp calls
+type GoalAuth interface {
+	GoalSystem
+	RegisterAuthenticator(name string, authenticator GoalAuthenticator)
+	Authenticate(r *http.Request) (*GoalIdentity, error)
+}
+
+type authConfig struct {
+	GoalSystemConfig
+	Required bool              `config:"required"`
+	JWT      authJWTConfig     `config:"jwt"`
+	APIKeys  map[string]string `config:"apiKeys" secret:"true"`
+}
+
+type authJWTConfig struct {
+	Secret   string `config:"secret" secret:"true"`
+	Issuer   string `config:"issuer"`
+	Audience string `config:"audience"`
+	Leeway   int64  `config:"leeway" validate:"min=0"`
+}
+
+type namedAuthenticator struct {
+	Name          string
+	Authenticator GoalAuthenticator
+}
+
+type auth struct {
+	GoalStatusTracker
+	required       bool
+	builtins       []namedAuthenticator
+	authenticators []namedAuthenticator
+	lock           sync.RWMutex
+}
+
+type identityContextKey struct{}
+
+func NewAuth() *auth {
+	return &auth{}
+}
+
+// WithIdentity returns a copy of the context carrying the identity
+func WithIdentity(ctx context.Context, identity *GoalIdentity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// GetIdentity returns the identity found in the context or in the session
+// it carries, or nil if the request or session was not authenticated
+func GetIdentity(ctx context.Context) *GoalIdentity {
+	identity, ok := ctx.Value(identityContextKey{}).(*GoalIdentity)
+	if ok {
+		return identity
+	}
+
+	session := GetSession(ctx)
+	if session == nil {
+		return nil
+	}
+
+	identity, _ = GetSessionAttribute[*GoalIdentity](session, IdentitySessionAttribute)
+
+	return identity
+}
+
+func (auth *auth) Setup(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	settings, err := auth.configure(config)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	logger := (*server.GetSystem("logger")).(GoalLogger).GetInstance()
+	logger.WithFields(LogFields{
+		"required": settings.Required,
+		"jwt":      settings.JWT.Secret != "",
+		"apiKeys":  len(settings.APIKeys),
+	}).Info("Setting up auth system")
+
+	auth.SetStatus(UpStatus, "")
+
+	return nil
+}
+
+func (auth *auth) Teardown(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	logger := (*server.GetSystem("logger")).(GoalLogger).GetInstance()
+	logger.Info("Tearing down auth system")
+	auth.SetStatus(DownStatus, "")
+
+	return nil
+}
+
+func (auth *auth) Reconfigure(server GoalServer, oldConfig *GoalConfig, newConfig *GoalConfig) error {
+	_, err := auth.configure(newConfig)
+
+	return err
+}
+
+func (auth *auth) GetDependencies() []string {
+	return []string{"logger"}
+}
+
+func (auth *auth) configure(config *GoalConfig) (*authConfig, error) {
+	settings := &authConfig{}
+	err := DecodeConfig(config, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	builtins := []namedAuthenticator{}
+	if settings.JWT.Secret != "" {
+		builtins = append(builtins, namedAuthenticator{"jwt", &jwtAuthenticator{
+			Secret:   []byte(settings.JWT.Secret),
+			Issuer:   settings.JWT.Issuer,
+			Audience: settings.JWT.Audience,
+			Leeway:   settings.JWT.Leeway,
+		}})
+	}
+
+	if len(settings.APIKeys) > 0 {
+		builtins = append(builtins, namedAuthenticator{"apiKey", apiKeyAuthenticator(settings.APIKeys)})
+	}
+
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+
+	auth.required = settings.Required
+	auth.builtins = builtins
+
+	return settings, nil
+}
+
+// RegisterAuthenticator adds an authenticator which is tried after the
+// built-in ones, in registration order
+func (auth *auth) RegisterAuthenticator(name string, authenticator GoalAuthenticator) {
+	auth.lock.Lock()
+	defer auth.lock.Unlock()
+
+	auth.authenticators = append(auth.authenticators, namedAuthenticator{name, authenticator})
+}
+
+// Authenticate returns the identity found by the first authenticator
+// recognizing the credentials of the request
+func (auth *auth) Authenticate(r *http.Request) (*GoalIdentity, error) {
+	auth.lock.RLock()
+	required := auth.required
+	authenticators := append(append([]namedAuthenticator{}, auth.builtins...), auth.authenticators...)
+	auth.lock.RUnlock()
+
+	for _, entry := range authenticators {
+		identity, err := entry.Authenticator.Authenticate(r)
+		if err != nil {
+			return nil, errors.Errorf("%s authentication failed: %v", entry.Name, err)
+		}
+
+		if identity != nil {
+			return identity, nil
+		}
+	}
+
+	if required {
+		return nil, errors.New("Authentication is required")
+	}
+
+	return nil, nil
+}
+
+type apiKeyAuthenticator map[string]string
+
+// Authenticate looks for a key in the X-API-Key header or the api_key
+// query parameter; the name of the matching key is used as subject
+func (keys apiKeyAuthenticator) Authenticate(r *http.Request) (*GoalIdentity, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = r.URL.Query().Get("api_key")
+	}
+
+	if key == "" {
+		return nil, nil
+	}
+
+	for name, expected := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(expected)) == 1 {
+			return &GoalIdentity{
+				Subject: name,
+				Method:  "apiKey",
+			}, nil
+		}
+	}
+
+	return nil, errors.New("unknown API key")
+}
+
+// getBearerToken looks for a token in the Authorization header, or in the
+// access_token query parameter since browsers cannot set headers on
+// WebSocket upgrades
+func getBearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+
+	return r.URL.Query().Get("access_token")
+}
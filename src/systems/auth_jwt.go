@@ -0,0 +1,134 @@
+package systems
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+var jwtAlgorithms = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+// jwtAuthenticator accepts bearer tokens signed with a shared HMAC secret
+type jwtAuthenticator struct {
+	Secret   []byte
+	Issuer   string
+	Audience string
+	Leeway   int64
+}
+
+func (authenticator *jwtAuthenticator) Authenticate(r *http.Request) (*GoalIdentity, error) {
+	token := getBearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+
+	claims, err := authenticator.verify(token, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+
+	return &GoalIdentity{
+		Subject: subject,
+		Method:  "jwt",
+		Claims:  claims,
+	}, nil
+}
+
+func (authenticator *jwtAuthenticator) verify(token string, now int64) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	header := struct {
+		Algorithm string `json:"alg"`
+	}{}
+
+	err := decodeJWTSegment(parts[0], &header)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, found := jwtAlgorithms[header.Algorithm]
+	if !found {
+		return nil, errors.Errorf("unsupported algorithm %s", header.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+
+	mac := hmac.New(algorithm, authenticator.Secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errors.New("invalid signature")
+	}
+
+	claims := map[string]interface{}{}
+	err = decodeJWTSegment(parts[1], &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if expiry, ok := claims["exp"].(float64); ok && now > int64(expiry)+authenticator.Leeway {
+		return nil, errors.New("token expired")
+	}
+
+	if notBefore, ok := claims["nbf"].(float64); ok && now < int64(notBefore)-authenticator.Leeway {
+		return nil, errors.New("token not valid yet")
+	}
+
+	if authenticator.Issuer != "" && claims["iss"] != authenticator.Issuer {
+		return nil, errors.New("unexpected issuer")
+	}
+
+	if authenticator.Audience != "" && !hasAudience(claims["aud"], authenticator.Audience) {
+		return nil, errors.New("unexpected audience")
+	}
+
+	return claims, nil
+}
+
+func decodeJWTSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+
+	err = json.Unmarshal(data, target)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+
+	return nil
+}
+
+func hasAudience(claim interface{}, audience string) bool {
+	switch claim := claim.(type) {
+	case string:
+		return claim == audience
+	case []interface{}:
+		for _, entry := range claim {
+			if entry == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,99 @@
+package systems_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/Wizcorp/goal/src/systems"
+)
+
+func signToken(secret string, claims string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(claims))
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(header + "." + payload))
+
+	return header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func startAuth(t *testing.T, settings map[string]interface{}) GoalAuth {
+	server := NewTestServer()
+	for key, value := range settings {
+		server.Config.Set("goal.auth."+key, value)
+	}
+
+	server.RegisterSystem(1, "auth", NewAuth())
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	t.Cleanup(func() {
+		server.Stop()
+	})
+
+	return (*server.GetSystem("auth")).(GoalAuth)
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	auth := startAuth(t, map[string]interface{}{
+		"jwt.secret": "secret",
+		"jwt.issuer": "goal",
+	})
+
+	valid := signToken("secret", `{"sub":"player","iss":"goal"}`)
+	expired := signToken("secret", `{"sub":"player","iss":"goal","exp":1}`)
+	forged := signToken("other", `{"sub":"player","iss":"goal"}`)
+
+	request := httptest.NewRequest("GET", "/messages", nil)
+	request.Header.Set("Authorization", "Bearer "+valid)
+
+	identity, err := auth.Authenticate(request)
+	if err != nil || identity == nil || identity.Subject != "player" || identity.Method != "jwt" {
+		t.Errorf("Valid token was not accepted: %v, %v", identity, err)
+	}
+
+	for _, token := range []string{expired, forged, "garbage"} {
+		request := httptest.NewRequest("GET", "/messages?access_token="+token, nil)
+
+		_, err := auth.Authenticate(request)
+		if err == nil {
+			t.Errorf("Invalid token was accepted: %s", token)
+		}
+	}
+
+	identity, err = auth.Authenticate(httptest.NewRequest("GET", "/messages", nil))
+	if identity != nil || err != nil {
+		t.Errorf("Anonymous request was not let through: %v, %v", identity, err)
+	}
+}
+
+func TestAuthenticateAPIKeys(t *testing.T) {
+	auth := startAuth(t, map[string]interface{}{
+		"required":        true,
+		"apiKeys.backend": "key",
+	})
+
+	request := httptest.NewRequest("GET", "/messages", nil)
+	request.Header.Set("X-API-Key", "key")
+
+	identity, err := auth.Authenticate(request)
+	if err != nil || identity == nil || identity.Subject != "backend" {
+		t.Errorf("Valid API key was not accepted: %v, %v", identity, err)
+	}
+
+	_, err = auth.Authenticate(httptest.NewRequest("GET", "/messages?api_key=wrong", nil))
+	if err == nil {
+		t.Error("Invalid API key was accepted")
+	}
+
+	_, err = auth.Authenticate(httptest.NewRequest("GET", "/messages", nil))
+	if err == nil {
+		t.Error("Anonymous request was accepted while authentication is required")
+	}
+}
@@ -1,18 +1,29 @@
 package systems
 
 import (
+	"context"
 	"crypto/md5"
+	"encoding/hex"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/AsynkronIT/protoactor-go/actor"
 	"github.com/AsynkronIT/protoactor-go/remote"
 	"github.com/go-errors/errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
 
 	. "github.com/Wizcorp/goal/src/api"
+	. "github.com/Wizcorp/goal/src/proto"
 )
 
 func init() {
-	RegisterSystem(3, "cluster", NewCluster())
+	RegisterSystemFactory(3, "cluster", func() GoalSystem {
+		return NewCluster()
+	})
+	RegisterConfigSchema("cluster", clusterConfig{})
 }
 
 type GoalClusterNode struct {
@@ -21,110 +32,365 @@ type GoalClusterNode struct {
 	Remote  *actor.PID
 }
 
+// GoalCluster routes messages to sessions connected to any node of the
+// cluster; room memberships are kept by each node, and broadcasts are
+// forwarded to every node so they can reach their own members
 type GoalCluster interface {
 	GoalSystem
+	ListNodes() map[string]GoalClusterNode
+	Broadcast(ctx context.Context, room string, messages ...proto.Message) error
+	EmitTo(ctx context.Context, sessionID string, messages ...proto.Message) error
+}
+
+type clusterConfig struct {
+	GoalSystemConfig
+	Enable  bool   `config:"enable"`
+	Name    string `config:"name" default:"goal"`
+	Address string `config:"address" default:"127.0.0.1:8081"`
+	// Defaults to a hash of the address and of the host's IP addresses
+	NodeID      string        `config:"nodeId"`
+	EmitTimeout time.Duration `config:"emitTimeout" default:"5s"`
+}
+
+// The remote actor server is global to the process; it is shared by the
+// clusters of all servers running in the process, and stopped along with
+// the last of them
+var clusterRemote = struct {
+	address    string
+	users      int
+	serializer sync.Once
+	lock       sync.Mutex
+}{}
+
+func startClusterRemote(address string) error {
+	clusterRemote.lock.Lock()
+	defer clusterRemote.lock.Unlock()
+
+	if clusterRemote.users > 0 && clusterRemote.address != address {
+		return errors.Errorf("Cluster remote is already listening on %s", clusterRemote.address)
+	}
+
+	if clusterRemote.users == 0 {
+		clusterRemote.serializer.Do(func() {
+			remote.RegisterSerializerAsDefault(clusterSerializer{})
+		})
+
+		remote.Start(address)
+		clusterRemote.address = address
+	}
+
+	clusterRemote.users++
+
+	return nil
+}
+
+func stopClusterRemote() {
+	clusterRemote.lock.Lock()
+	defer clusterRemote.lock.Unlock()
+
+	clusterRemote.users--
+	if clusterRemote.users == 0 {
+		remote.Shutdown(true)
+	}
+}
+
+// getClusterActorName returns the name of the actor receiving the
+// messages sent to a node
+func getClusterActorName(nodeID string) string {
+	return "cluster-" + nodeID
 }
 
 type cluster struct {
-	Status  Status
-	Name    string
-	NodeID  string
-	Address string
-	Remote  remote.RemotingServer
-	Nodes   map[string]GoalClusterNode
-	Tracker GoalDiscoveryTracker
+	GoalStatusTracker
+	Enabled     bool
+	Name        string
+	NodeID      string
+	Address     string
+	EmitTimeout time.Duration
+	Nodes       map[string]GoalClusterNode
+	Tracker     GoalDiscoveryTracker
+	Actor       *actor.PID
+	Logger      GoalLogger
+	Rooms       GoalRooms
+	Services    GoalServices
+	lock        sync.RWMutex
 }
 
 func NewCluster() *cluster {
 	return &cluster{
-		Status: DownStatus,
+		Nodes: map[string]GoalClusterNode{},
 	}
 }
 
-func (cluster *cluster) Setup(server GoalServer, config *GoalConfig) error {
-	var err error
+func (cluster *cluster) Setup(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	settings := &clusterConfig{}
+	err := DecodeConfig(config, settings)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	cluster.Logger = (*server.GetSystem("logger")).(GoalLogger)
+	cluster.Rooms = (*server.GetSystem("rooms")).(GoalRooms)
+	cluster.Services = (*server.GetSystem("services")).(GoalServices)
 
-	isEnabled := config.Bool("enable", false)
-	if !isEnabled {
+	cluster.Enabled = settings.Enable
+	if !cluster.Enabled {
+		cluster.SetStatus(UpStatus, "disabled")
 		return nil
 	}
 
-	cluster.Name = config.String("name", "goal")
-	cluster.Address = config.String("address", "127.0.0.1:8081")
-	cluster.NodeID, err = cluster.getClusterNodeID()
-	if err != nil {
-		return errors.Wrap(err, 0)
+	cluster.Name = settings.Name
+	cluster.Address = settings.Address
+	cluster.EmitTimeout = settings.EmitTimeout
+	cluster.NodeID = settings.NodeID
+	if cluster.NodeID == "" {
+		cluster.NodeID, err = cluster.getClusterNodeID()
+		if err != nil {
+			return errors.Wrap(err, 0)
+		}
 	}
 
-	logger := (*server.GetSystem("logger")).(GoalLogger).GetInstance()
+	logger := cluster.Logger.GetInstance()
 	logger.WithFields(LogFields{
 		"name":    cluster.Name,
 		"address": cluster.Address,
 		"nodeId":  cluster.NodeID,
 	}).Info("Setting up cluster system")
 
-	remote.Start(cluster.Address)
+	err = startClusterRemote(cluster.Address)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	cluster.Actor, err = actor.SpawnNamed(actor.FromFunc(cluster.receive), getClusterActorName(cluster.NodeID))
+	if err != nil {
+		stopClusterRemote()
+		return errors.Wrap(err, 0)
+	}
 
 	discovery := (*server.GetSystem("discovery")).(GoalDiscovery)
 	allTag := "all"
 
-	discovery.RegisterService(cluster.Name, cluster.NodeID, []string{
+	err = discovery.RegisterService(cluster.Name, cluster.NodeID, []string{
 		"all",
 	}, cluster.Address)
 
+	if err != nil {
+		cluster.Actor.GracefulStop()
+		stopClusterRemote()
+		return errors.Wrap(err, 0)
+	}
+
 	cluster.Tracker = discovery.TrackService(cluster.Name, allTag)
 
 	go func() {
-		for {
-			update := <-cluster.Tracker.UpdateChannel
+		for update := range cluster.Tracker.UpdateChannel {
+			if update.Info.ServiceID == cluster.NodeID {
+				continue
+			}
+
 			if update.Add {
-				cluster.AddNode(update.Info.ID, update.Info.Address)
+				cluster.AddNode(update.Info.ServiceID, GetServiceAddress(update.Info))
 			}
 			if update.Remove {
-				cluster.RemoveNode(update.Info.ID)
+				cluster.RemoveNode(update.Info.ServiceID)
 			}
 		}
 	}()
 
-	cluster.Status = UpStatus
+	cluster.SetStatus(UpStatus, "")
 
 	return nil
 }
 
-func (cluster *cluster) Teardown(server GoalServer, config *GoalConfig) error {
-	cluster.Status = DownStatus
+func (cluster *cluster) Teardown(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	cluster.SetStatus(DownStatus, "")
+
+	if !cluster.Enabled {
+		return nil
+	}
 
 	cluster.Tracker.Stop()
+	cluster.Actor.GracefulStop()
 	discovery := (*server.GetSystem("discovery")).(GoalDiscovery)
 	err := discovery.DeregisterService(cluster.NodeID)
-	remote.Shutdown(true)
+	stopClusterRemote()
+
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
 
-	return errors.Wrap(err, 0)
+	return nil
 }
 
-func (cluster *cluster) GetStatus() Status {
-	return cluster.Status
+func (cluster *cluster) GetDependencies() []string {
+	return []string{"logger", "discovery", "rooms", "services"}
 }
 
 func (cluster *cluster) AddNode(id string, address string) {
+	cluster.lock.Lock()
+	defer cluster.lock.Unlock()
+
 	cluster.Nodes[id] = GoalClusterNode{
 		ID:      id,
 		Address: address,
-		Remote:  actor.NewPID(address, "cluster"),
+		Remote:  actor.NewPID(address, getClusterActorName(id)),
 	}
 }
 
 func (cluster *cluster) RemoveNode(id string) {
+	cluster.lock.Lock()
+	defer cluster.lock.Unlock()
+
 	delete(cluster.Nodes, id)
 }
 
+// ListNodes returns the other nodes of the cluster, by ID
 func (cluster *cluster) ListNodes() map[string]GoalClusterNode {
-	return cluster.Nodes
+	cluster.lock.RLock()
+	defer cluster.lock.RUnlock()
+
+	nodes := map[string]GoalClusterNode{}
+	for id, node := range cluster.Nodes {
+		nodes[id] = node
+	}
+
+	return nodes
+}
+
+// Broadcast emits messages to the members of a room on this node, and
+// forwards them to the other nodes; only local delivery errors are returned
+func (cluster *cluster) Broadcast(ctx context.Context, room string, messages ...proto.Message) error {
+	err := cluster.forward(&GoalClusterMessage{Room: room}, messages)
+	if err != nil {
+		return err
+	}
+
+	return cluster.Rooms.Broadcast(ctx, room, messages...)
+}
+
+// EmitTo emits messages to a session; sessions which are not connected to
+// this node are looked up by forwarding the messages to the other nodes, and
+// ErrSessionNotFound is returned if none of them delivered the messages
+func (cluster *cluster) EmitTo(ctx context.Context, sessionID string, messages ...proto.Message) error {
+	err := cluster.Services.EmitTo(ctx, sessionID, messages...)
+	if err != ErrSessionNotFound || !cluster.Enabled {
+		return err
+	}
+
+	message, err := packClusterMessage(&GoalClusterMessage{Session: sessionID}, messages)
+	if err != nil {
+		return err
+	}
+
+	futures := []*actor.Future{}
+	for _, node := range cluster.ListNodes() {
+		futures = append(futures, node.Remote.RequestFuture(message, cluster.EmitTimeout))
+	}
+
+	var failure error
+	for _, future := range futures {
+		result, err := future.Result()
+		if err != nil {
+			failure = errors.Wrap(err, 0)
+			continue
+		}
+
+		if delivery, ok := result.(*GoalClusterDelivery); ok && delivery.Delivered {
+			return nil
+		}
+	}
+
+	if failure != nil {
+		return failure
+	}
+
+	return ErrSessionNotFound
+}
+
+func (cluster *cluster) forward(message *GoalClusterMessage, messages []proto.Message) error {
+	if !cluster.Enabled {
+		return nil
+	}
+
+	message, err := packClusterMessage(message, messages)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range cluster.ListNodes() {
+		node.Remote.Tell(message)
+	}
+
+	return nil
+}
+
+func packClusterMessage(message *GoalClusterMessage, messages []proto.Message) (*GoalClusterMessage, error) {
+	for _, content := range messages {
+		anyMessage, err := ptypes.MarshalAny(content)
+		if err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+
+		message.Messages = append(message.Messages, anyMessage)
+	}
+
+	return message, nil
+}
+
+// receive delivers messages forwarded by other nodes to local sessions;
+// messages sent to a session are answered with whether they were delivered
+func (cluster *cluster) receive(actorContext actor.Context) {
+	message, ok := actorContext.Message().(*GoalClusterMessage)
+	if !ok {
+		return
+	}
+
+	err := cluster.deliver(message)
+	if message.Session != "" && actorContext.Sender() != nil {
+		actorContext.Respond(&GoalClusterDelivery{Delivered: err == nil})
+	}
+
+	if err != nil && err != ErrSessionNotFound {
+		cluster.Logger.GetInstance().WithFields(LogFields{
+			"room":    message.Room,
+			"session": message.Session,
+			"error":   err,
+		}).Warn("Failed to deliver cluster message")
+	}
+}
+
+func (cluster *cluster) deliver(message *GoalClusterMessage) error {
+	messages, err := unpackClusterMessages(message.Messages)
+	if err != nil {
+		return err
+	}
+
+	ctx := WithMessageID(context.Background(), 0)
+	if message.Room != "" {
+		return cluster.Rooms.Broadcast(ctx, message.Room, messages...)
+	}
+
+	return cluster.Services.EmitTo(ctx, message.Session, messages...)
+}
+
+func unpackClusterMessages(anyMessages []*any.Any) ([]proto.Message, error) {
+	messages := []proto.Message{}
+	for _, anyMessage := range anyMessages {
+		var message ptypes.DynamicAny
+		err := ptypes.UnmarshalAny(anyMessage, &message)
+		if err != nil {
+			return nil, errors.Wrap(err, 0)
+		}
+
+		messages = append(messages, message.Message)
+	}
+
+	return messages, nil
 }
 
 func (cluster *cluster) getClusterNodeID() (string, error) {
-	var content []byte
-	copy(content[:], cluster.Address)
+	content := []byte(cluster.Address)
 
 	ifaces, err := net.Interfaces()
 	if err != nil {
@@ -147,5 +413,5 @@ func (cluster *cluster) getClusterNodeID() (string, error) {
 
 	hash := md5.Sum(content)
 
-	return string(hash[:]), nil
+	return hex.EncodeToString(hash[:]), nil
 }
@@ -0,0 +1,176 @@
+//go:build !race
+
+// The remote of protoactor pushes to its mailboxes with a plain write that
+// the race detector reports against the atomic reads of their consumers, so
+// the tests starting cluster remotes are skipped by race-enabled runs
+package systems_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AsynkronIT/protoactor-go/actor"
+	"github.com/AsynkronIT/protoactor-go/remote"
+	"github.com/golang/protobuf/proto"
+
+	. "github.com/Wizcorp/goal/src/proto"
+	. "github.com/Wizcorp/goal/src/systems"
+)
+
+type lockedConnection struct {
+	recordingConnection
+	lock sync.Mutex
+}
+
+func (conn *lockedConnection) WriteMessage(messageType int, data []byte) error {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	return conn.recordingConnection.WriteMessage(messageType, data)
+}
+
+func (conn *lockedConnection) Count() int {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	return len(conn.Messages)
+}
+
+type clusterMember struct {
+	Services GoalServices
+	Rooms    GoalRooms
+	Cluster  GoalCluster
+}
+
+// startClusterMembers starts a server per node ID, all of them sharing
+// the remote of the process and discovering each other through a fake catalog
+func startClusterMembers(t *testing.T, nodeIDs ...string) []clusterMember {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+
+	address := listener.Addr().(*net.TCPAddr)
+	listener.Close()
+
+	instances := []string{}
+	for _, nodeID := range nodeIDs {
+		instances = append(instances, fmt.Sprintf(`{"ServiceID": "%s", "ServiceAddress": "127.0.0.1", "ServicePort": %d}`, nodeID, address.Port))
+	}
+
+	catalog := newConsulCatalog("[" + strings.Join(instances, ",") + "]")
+	t.Cleanup(catalog.Close)
+
+	members := []clusterMember{}
+	for _, nodeID := range nodeIDs {
+		server := NewTestServer()
+		server.Config.Set("goal.discovery.enable", true)
+		server.Config.Set("goal.discovery.address", strings.TrimPrefix(catalog.URL, "http://"))
+		server.Config.Set("goal.cluster.enable", true)
+		server.Config.Set("goal.cluster.address", address.String())
+		server.Config.Set("goal.cluster.nodeId", nodeID)
+		server.Config.Set("goal.cluster.emitTimeout", "1s")
+		server.RegisterSystem(1, "discovery", NewDiscovery())
+		server.RegisterSystem(1, "services", NewEmptyServices())
+		server.RegisterSystem(2, "rooms", NewRooms())
+		server.RegisterSystem(3, "cluster", NewCluster())
+
+		err := server.Start()
+		if err != nil {
+			t.Fatalf("Failed to start server %s: %v", nodeID, err)
+		}
+
+		t.Cleanup(func() {
+			server.Stop()
+		})
+
+		members = append(members, clusterMember{
+			Services: (*server.GetSystem("services")).(GoalServices),
+			Rooms:    (*server.GetSystem("rooms")).(GoalRooms),
+			Cluster:  (*server.GetSystem("cluster")).(GoalCluster),
+		})
+	}
+
+	for _, member := range members {
+		waitFor(t, "nodes to be discovered", func() bool {
+			return len(member.Cluster.ListNodes()) == len(nodeIDs)-1
+		})
+	}
+
+	return members
+}
+
+func waitFor(t *testing.T, description string, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", description)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClusterDeliversToRemoteSessions(t *testing.T) {
+	members := startClusterMembers(t, "first", "second")
+	first, second := members[0], members[1]
+
+	conn := &lockedConnection{}
+	session := NewSession(conn, "player", &ProtobufCodec{}, first.Services.EmitMessages)
+	first.Services.Connect(context.Background(), session)
+	first.Rooms.Join("match", session)
+
+	err := second.Cluster.Broadcast(context.Background(), "match", &GoalPingResponse{Timestamp: 1})
+	if err != nil {
+		t.Fatalf("Failed to broadcast: %v", err)
+	}
+
+	waitFor(t, "the broadcast to be delivered", func() bool {
+		return conn.Count() == 1
+	})
+
+	err = second.Cluster.EmitTo(context.Background(), session.GetID(), &GoalPingResponse{Timestamp: 2})
+	if err != nil {
+		t.Fatalf("Failed to emit: %v", err)
+	}
+
+	if conn.Count() != 2 {
+		t.Errorf("Expected 2 messages, got %d", conn.Count())
+	}
+
+	err = second.Cluster.EmitTo(context.Background(), "missing", &GoalPingResponse{Timestamp: 3})
+	if err != ErrSessionNotFound {
+		t.Errorf("Emitting to a session connected to no node should fail, got %v", err)
+	}
+}
+
+func TestClusterSerializer(t *testing.T) {
+	// The serializer is registered by the first cluster to be enabled
+	startClusterMembers(t, "serializer")
+
+	messages := []proto.Message{
+		&GoalClusterMessage{Room: "match", Session: "player"},
+		actor.NewPID("127.0.0.1:8081", "cluster"),
+	}
+
+	for _, message := range messages {
+		data, typeName, err := remote.Serialize(message, remote.DefaultSerializerID)
+		if err != nil {
+			t.Fatalf("Failed to serialize %T: %v", message, err)
+		}
+
+		decoded, err := remote.Deserialize(data, typeName, remote.DefaultSerializerID)
+		if err != nil {
+			t.Fatalf("Failed to deserialize %s: %v", typeName, err)
+		}
+
+		if decoded.(proto.Message).String() != message.String() {
+			t.Errorf("Expected %v, got %v", message, decoded)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package systems
+
+import (
+	"reflect"
+
+	"github.com/go-errors/errors"
+	gogo "github.com/gogo/protobuf/proto"
+	"github.com/golang/protobuf/proto"
+)
+
+// clusterSerializer lets remote actors exchange messages generated with
+// golang/protobuf; messages it does not know about, such as the ones used
+// internally by protoactor, are handled by gogo/protobuf
+type clusterSerializer struct{}
+
+func (clusterSerializer) Serialize(message interface{}) ([]byte, error) {
+	if message, ok := message.(proto.Message); ok && proto.MessageName(message) != "" {
+		return proto.Marshal(message)
+	}
+
+	if message, ok := message.(gogo.Message); ok {
+		return gogo.Marshal(message)
+	}
+
+	return nil, errors.Errorf("Cannot serialize %T, it is not a protobuf message", message)
+}
+
+func (clusterSerializer) Deserialize(typeName string, data []byte) (interface{}, error) {
+	if messageType := proto.MessageType(typeName); messageType != nil {
+		message := reflect.New(messageType.Elem()).Interface().(proto.Message)
+		return message, proto.Unmarshal(data, message)
+	}
+
+	if messageType := gogo.MessageType(typeName); messageType != nil {
+		message := reflect.New(messageType.Elem()).Interface().(gogo.Message)
+		return message, gogo.Unmarshal(data, message)
+	}
+
+	return nil, errors.Errorf("Unknown message type %s", typeName)
+}
+
+func (clusterSerializer) GetTypeName(message interface{}) (string, error) {
+	if message, ok := message.(proto.Message); ok {
+		if name := proto.MessageName(message); name != "" {
+			return name, nil
+		}
+	}
+
+	if message, ok := message.(gogo.Message); ok {
+		if name := gogo.MessageName(message); name != "" {
+			return name, nil
+		}
+	}
+
+	return "", errors.Errorf("Cannot name %T, it is not a registered protobuf message", message)
+}
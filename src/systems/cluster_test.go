@@ -0,0 +1,54 @@
+package systems_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/Wizcorp/goal/src/proto"
+	. "github.com/Wizcorp/goal/src/systems"
+)
+
+func TestClusterDeliversToLocalSessions(t *testing.T) {
+	server := NewTestServer()
+	server.RegisterSystem(1, "discovery", NewDiscovery())
+	server.RegisterSystem(1, "services", NewEmptyServices())
+	server.RegisterSystem(2, "rooms", NewRooms())
+	server.RegisterSystem(3, "cluster", NewCluster())
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	defer server.Stop()
+
+	services := (*server.GetSystem("services")).(GoalServices)
+	rooms := (*server.GetSystem("rooms")).(GoalRooms)
+	cluster := (*server.GetSystem("cluster")).(GoalCluster)
+
+	conn := &recordingConnection{}
+	session := NewSession(conn, "player", &ProtobufCodec{}, services.EmitMessages)
+	services.Connect(context.Background(), session)
+	rooms.Join("match", session)
+
+	err = cluster.Broadcast(context.Background(), "match", &GoalPingResponse{Timestamp: 1})
+	if err != nil {
+		t.Fatalf("Failed to broadcast: %v", err)
+	}
+
+	err = cluster.EmitTo(context.Background(), session.GetID(), &GoalPingResponse{Timestamp: 2})
+	if err != nil {
+		t.Fatalf("Failed to emit: %v", err)
+	}
+
+	if len(conn.Messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %d", len(conn.Messages))
+	}
+
+	services.Disconnect(context.Background(), session)
+
+	err = cluster.EmitTo(context.Background(), session.GetID(), &GoalPingResponse{Timestamp: 3})
+	if err == nil {
+		t.Error("Emitting to a disconnected session should fail")
+	}
+}
@@ -0,0 +1,127 @@
+package systems
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/websocket"
+
+	. "github.com/Wizcorp/goal/src/proto"
+)
+
+// GoalCodec encodes and decodes the envelopes exchanged over message streams
+// for a given media type; clients select a codec through the Content-Type
+// header of the upgrade request, or through the WebSocket subprotocol
+type GoalCodec interface {
+	GetMediaType() string
+	GetSubprotocol() string
+	GetMessageType() int
+	Marshal(envelope *GoalMessageEnvelope) ([]byte, error)
+	Unmarshal(data []byte, envelope *GoalMessageEnvelope) error
+}
+
+var codecs = map[string]GoalCodec{}
+
+func init() {
+	RegisterCodec(&JSONCodec{})
+	RegisterCodec(&ProtobufCodec{})
+}
+
+// RegisterCodec makes a codec available to message streams; it replaces
+// any codec previously registered for the same media type, and must be
+// called before the server is started
+func RegisterCodec(codec GoalCodec) {
+	codecs[codec.GetMediaType()] = codec
+}
+
+// UnregisterCodec removes the codec registered for a media type
+func UnregisterCodec(mediaType string) {
+	delete(codecs, mediaType)
+}
+
+// GetCodec returns the codec registered for a media type
+func GetCodec(mediaType string) (GoalCodec, bool) {
+	codec, found := codecs[mediaType]
+
+	return codec, found
+}
+
+// GetCodecBySubprotocol returns the codec registered for a WebSocket subprotocol
+func GetCodecBySubprotocol(subprotocol string) (GoalCodec, bool) {
+	for _, codec := range codecs {
+		if codec.GetSubprotocol() != "" && codec.GetSubprotocol() == subprotocol {
+			return codec, true
+		}
+	}
+
+	return nil, false
+}
+
+// GetCodecs returns the registered codecs, sorted by media type
+func GetCodecs() []GoalCodec {
+	mediaTypes := []string{}
+	for mediaType := range codecs {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+
+	sort.Strings(mediaTypes)
+
+	list := []GoalCodec{}
+	for _, mediaType := range mediaTypes {
+		list = append(list, codecs[mediaType])
+	}
+
+	return list
+}
+
+// JSONCodec encodes envelopes with the JSON mapping of protobuf messages
+type JSONCodec struct{}
+
+func (codec *JSONCodec) GetMediaType() string {
+	return "application/json"
+}
+
+func (codec *JSONCodec) GetSubprotocol() string {
+	return "goal.v1.json"
+}
+
+func (codec *JSONCodec) GetMessageType() int {
+	return websocket.TextMessage
+}
+
+func (codec *JSONCodec) Marshal(envelope *GoalMessageEnvelope) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	marshaler := jsonpb.Marshaler{}
+	err := marshaler.Marshal(buffer, envelope)
+
+	return buffer.Bytes(), err
+}
+
+func (codec *JSONCodec) Unmarshal(data []byte, envelope *GoalMessageEnvelope) error {
+	return jsonpb.Unmarshal(bytes.NewReader(data), envelope)
+}
+
+// ProtobufCodec encodes envelopes in the protobuf wire format
+type ProtobufCodec struct{}
+
+func (codec *ProtobufCodec) GetMediaType() string {
+	return "application/protobuf"
+}
+
+func (codec *ProtobufCodec) GetSubprotocol() string {
+	return "goal.v1.proto"
+}
+
+func (codec *ProtobufCodec) GetMessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (codec *ProtobufCodec) Marshal(envelope *GoalMessageEnvelope) ([]byte, error) {
+	return proto.Marshal(envelope)
+}
+
+func (codec *ProtobufCodec) Unmarshal(data []byte, envelope *GoalMessageEnvelope) error {
+	return proto.Unmarshal(data, envelope)
+}
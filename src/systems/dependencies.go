@@ -0,0 +1,148 @@
+package systems
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/go-errors/errors"
+)
+
+type dependencyGraph struct {
+	names        []string
+	dependencies map[string][]string
+}
+
+// resolveRunlevels orders the registered systems so that every system
+// is placed in a runlevel after all of the systems it depends on
+func (server *server) resolveRunlevels() ([]GoalRunlevel, error) {
+	graph := server.buildDependencyGraph()
+
+	err := graph.checkMissing(server.Systems)
+	if err != nil {
+		return nil, err
+	}
+
+	levels := map[string]int{}
+	visiting := map[string]bool{}
+
+	for _, name := range graph.names {
+		_, err := graph.resolveLevel(name, levels, visiting, []string{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	runlevels := []GoalRunlevel{}
+	for _, name := range graph.names {
+		level := levels[name]
+		for len(runlevels) <= level {
+			runlevels = append(runlevels, GoalRunlevel{})
+		}
+
+		runlevels[level][name] = server.Systems[name]
+	}
+
+	return runlevels, nil
+}
+
+func (server *server) buildDependencyGraph() *dependencyGraph {
+	graph := &dependencyGraph{
+		names:        []string{},
+		dependencies: map[string][]string{},
+	}
+
+	runlevels := []int{}
+	for runlevel := range server.runlevels {
+		runlevels = append(runlevels, runlevel)
+	}
+
+	sort.Ints(runlevels)
+
+	// Systems without declared dependencies keep the historical behavior
+	// of depending on every system found in previous runlevels
+	previous := []string{}
+	for _, runlevel := range runlevels {
+		names := sortedNames(server.runlevels[runlevel])
+
+		for _, name := range names {
+			system := server.runlevels[runlevel][name]
+
+			if system, ok := system.(GoalSystemWithDependencies); ok {
+				graph.dependencies[name] = system.GetDependencies()
+			} else {
+				graph.dependencies[name] = previous
+			}
+
+			graph.names = append(graph.names, name)
+		}
+
+		previous = append(append([]string{}, previous...), names...)
+	}
+
+	return graph
+}
+
+func (graph *dependencyGraph) checkMissing(systems map[string]GoalSystem) error {
+	missing := []string{}
+
+	for _, name := range graph.names {
+		for _, dependency := range graph.dependencies[name] {
+			if systems[dependency] == nil {
+				missing = append(missing, name+" -> "+dependency)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return errors.Errorf("Systems depend on unregistered systems: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func (graph *dependencyGraph) resolveLevel(name string, levels map[string]int, visiting map[string]bool, path []string) (int, error) {
+	if level, found := levels[name]; found {
+		return level, nil
+	}
+
+	path = append(path, name)
+
+	if visiting[name] {
+		start := 0
+		for path[start] != name {
+			start++
+		}
+
+		return 0, errors.Errorf("Dependency cycle detected between systems: %s", strings.Join(path[start:], " -> "))
+	}
+
+	visiting[name] = true
+
+	level := 0
+	for _, dependency := range graph.dependencies[name] {
+		dependencyLevel, err := graph.resolveLevel(dependency, levels, visiting, path)
+		if err != nil {
+			return 0, err
+		}
+
+		if dependencyLevel+1 > level {
+			level = dependencyLevel + 1
+		}
+	}
+
+	visiting[name] = false
+	levels[name] = level
+
+	return level, nil
+}
+
+func sortedNames(runlevel GoalRunlevel) []string {
+	names := []string{}
+	for name := range runlevel {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -2,6 +2,8 @@ package systems
 
 import (
 	"context"
+	"net"
+	"strconv"
 	"time"
 
 	"github.com/go-errors/errors"
@@ -11,7 +13,10 @@ import (
 )
 
 func init() {
-	RegisterSystem(2, "discovery", NewDiscovery())
+	RegisterSystemFactory(2, "discovery", func() GoalSystem {
+		return NewDiscovery()
+	})
+	RegisterConfigSchema("discovery", discoveryConfig{})
 }
 
 type GoalDiscovery interface {
@@ -21,8 +26,15 @@ type GoalDiscovery interface {
 	TrackService(name string, tag string) GoalDiscoveryTracker
 }
 
+type discoveryConfig struct {
+	GoalSystemConfig
+	Enable  bool   `config:"enable"`
+	Address string `config:"address" default:"127.0.0.1:8500"`
+	Scheme  string `config:"scheme" default:"http" validate:"oneof=http|https"`
+}
+
 type discovery struct {
-	Status Status
+	GoalStatusTracker
 	Consul *consul.Client
 	Logger GoalLogger
 }
@@ -39,20 +51,24 @@ type GoalDiscoveryTracker struct {
 }
 
 func NewDiscovery() *discovery {
-	return &discovery{
-		Status: DownStatus,
-	}
+	return &discovery{}
 }
 
-func (discovery *discovery) Setup(server GoalServer, config *GoalConfig) error {
-	isEnabled := config.Bool("enable", false)
-	if !isEnabled {
+func (discovery *discovery) Setup(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	settings := &discoveryConfig{}
+	err := DecodeConfig(config, settings)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	if !settings.Enable {
+		discovery.SetStatus(UpStatus, "disabled")
 		return nil
 	}
 
 	consulConfig := consul.DefaultConfig()
-	consulConfig.Address = config.String("address", "127.0.0.1:8500")
-	consulConfig.Scheme = config.String("scheme", "http")
+	consulConfig.Address = settings.Address
+	consulConfig.Scheme = settings.Scheme
 
 	discovery.Logger = (*server.GetSystem("logger")).(GoalLogger)
 	discovery.Logger.GetInstance().WithFields(LogFields{
@@ -67,34 +83,46 @@ func (discovery *discovery) Setup(server GoalServer, config *GoalConfig) error {
 		return errors.Wrap(err, 0)
 	}
 
-	discovery.Status = UpStatus
+	discovery.SetStatus(UpStatus, "")
 
 	return nil
 }
 
-func (discovery *discovery) Teardown(server GoalServer, config *GoalConfig) error {
+func (discovery *discovery) Teardown(ctx context.Context, server GoalServer, config *GoalConfig) error {
 	logger := (*server.GetSystem("logger")).(GoalLogger).GetInstance()
 	logger.Info("Tearing down discovery system")
-	discovery.Status = DownStatus
+	discovery.SetStatus(DownStatus, "")
 
 	return nil
 }
 
-func (discovery *discovery) GetStatus() Status {
-	return UpStatus
+func (discovery *discovery) GetDependencies() []string {
+	return []string{"logger"}
 }
 
 func (discovery *discovery) RegisterService(name string, id string, tags []string, address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
 	service := &consul.AgentServiceRegistration{
-		ID:   id,
-		Name: name,
-		Tags: tags,
+		ID:      id,
+		Name:    name,
+		Tags:    tags,
+		Address: host,
+		Port:    portNumber,
 		Check: &consul.AgentServiceCheck{
 			TTL: (10 * time.Second).String(),
 		},
 	}
 
-	err := discovery.Consul.Agent().ServiceRegister(service)
+	err = discovery.Consul.Agent().ServiceRegister(service)
 	if err != nil {
 		return errors.Wrap(err, 0)
 	}
@@ -106,46 +134,70 @@ func (discovery *discovery) DeregisterService(id string) error {
 	return discovery.Consul.Agent().ServiceDeregister(id)
 }
 
+// TrackService watches the instances of a service, and reports them as they
+// are added or removed; the update channel is closed once the tracker is stopped
 func (discovery *discovery) TrackService(name string, tag string) GoalDiscoveryTracker {
-	stop := false
 	ctx, cancel := context.WithCancel(context.Background())
 	updateChannel := make(chan GoalDiscoveryUpdate)
 
 	go func() {
-		logger := discovery.Logger.GetInstance()
-
-		if stop {
-			return
-		}
+		defer close(updateChannel)
 
+		logger := discovery.Logger.GetInstance()
 		knownInstances := []*consul.CatalogService{}
+		waitIndex := uint64(0)
+
 		for {
-			ctx, cancel = context.WithCancel(context.Background())
+			queryCtx, cancelQuery := context.WithCancel(ctx)
 			opts := &consul.QueryOptions{
 				RequireConsistent: true,
+				WaitIndex:         waitIndex,
 			}
-			opts.WithContext(ctx)
-			newInstances, _, err := discovery.Consul.Catalog().Service(name, "all", opts)
+			opts = opts.WithContext(queryCtx)
+			newInstances, meta, err := discovery.Consul.Catalog().Service(name, "all", opts)
+			cancelQuery()
 
 			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+
 				logger.WithFields(LogFields{
 					"service": name,
 					"tag":     tag,
 					"error":   err,
 				}).Error("Error during discovery")
+
+				select {
+				case <-time.After(time.Second):
+					continue
+				case <-ctx.Done():
+					return
+				}
 			}
 
+			waitIndex = meta.LastIndex
+			updates := []GoalDiscoveryUpdate{}
+
 			for _, knownInstance := range findMissingEntriesFrom(knownInstances, newInstances) {
-				updateChannel <- GoalDiscoveryUpdate{
+				updates = append(updates, GoalDiscoveryUpdate{
 					Remove: true,
 					Info:   knownInstance,
-				}
+				})
 			}
 
 			for _, newInstance := range findMissingEntriesFrom(newInstances, knownInstances) {
-				updateChannel <- GoalDiscoveryUpdate{
+				updates = append(updates, GoalDiscoveryUpdate{
 					Add:  true,
 					Info: newInstance,
+				})
+			}
+
+			for _, update := range updates {
+				select {
+				case updateChannel <- update:
+				case <-ctx.Done():
+					return
 				}
 			}
 
@@ -155,27 +207,36 @@ func (discovery *discovery) TrackService(name string, tag string) GoalDiscoveryT
 
 	return GoalDiscoveryTracker{
 		UpdateChannel: updateChannel,
-		Stop: func() {
-			cancel()
-		},
+		Stop:          cancel,
 	}
 }
 
+// findMissingEntriesFrom returns the instances of source which cannot be found in compare
 func findMissingEntriesFrom(source []*consul.CatalogService, compare []*consul.CatalogService) []*consul.CatalogService {
 	missing := []*consul.CatalogService{}
-	for _, newInstance := range compare {
-		for _, knownInstance := range source {
-			found := false
-			if knownInstance.Address == newInstance.Address {
+	for _, instance := range source {
+		found := false
+		for _, other := range compare {
+			if instance.ServiceID == other.ServiceID {
 				found = true
 				break
 			}
+		}
 
-			if !found {
-				missing = append(missing, newInstance)
-			}
+		if !found {
+			missing = append(missing, instance)
 		}
 	}
 
 	return missing
 }
+
+// GetServiceAddress returns the host:port address a service instance was registered with
+func GetServiceAddress(instance *consul.CatalogService) string {
+	host := instance.ServiceAddress
+	if host == "" {
+		host = instance.Address
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(instance.ServicePort))
+}
@@ -0,0 +1,65 @@
+package systems_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/Wizcorp/goal/src/systems"
+)
+
+// newConsulCatalog serves the given instances of any service, accepts any
+// other request, and blocks queries waiting for changes until they are cancelled
+func newConsulCatalog(instances string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/v1/catalog/service/") {
+			return
+		}
+
+		if r.URL.Query().Get("index") != "" {
+			<-r.Context().Done()
+			return
+		}
+
+		w.Header().Set("X-Consul-Index", "1")
+		w.Write([]byte(instances))
+	}))
+}
+
+func TestTrackServiceStopsPendingQueries(t *testing.T) {
+	catalog := newConsulCatalog(`[{"ServiceID": "node", "ServiceAddress": "127.0.0.1", "ServicePort": 8081}]`)
+	defer catalog.Close()
+
+	server := NewTestServer()
+	server.Config.Set("goal.discovery.enable", true)
+	server.Config.Set("goal.discovery.address", strings.TrimPrefix(catalog.URL, "http://"))
+	server.RegisterSystem(1, "discovery", NewDiscovery())
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	defer server.Stop()
+
+	discovery := (*server.GetSystem("discovery")).(GoalDiscovery)
+	tracker := discovery.TrackService("goal", "all")
+
+	update := <-tracker.UpdateChannel
+	if !update.Add || GetServiceAddress(update.Info) != "127.0.0.1:8081" {
+		t.Errorf("Unexpected update: %+v", update)
+	}
+
+	tracker.Stop()
+
+	select {
+	case _, open := <-tracker.UpdateChannel:
+		if open {
+			t.Error("Unexpected update after stopping the tracker")
+		}
+	case <-time.After(time.Second):
+		t.Error("Tracker did not stop while waiting for changes")
+	}
+}
@@ -0,0 +1,258 @@
+package systems
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+// Models used by the services system to dispatch received envelopes
+const (
+	// InlineDispatch processes envelopes on the goroutine reading the connection
+	InlineDispatch = "inline"
+	// SessionDispatch processes the envelopes of each session, in order, on
+	// a goroutine of their own
+	SessionDispatch = "session"
+	// PoolDispatch processes envelopes on a fixed number of workers; envelopes
+	// sharing the same dispatch key are processed in order by the same worker
+	PoolDispatch = "pool"
+)
+
+type servicesDispatchConfig struct {
+	Mode      string `config:"mode" default:"inline" validate:"oneof=inline|session|pool"`
+	Workers   int64  `config:"workers" default:"16" validate:"min=1"`
+	QueueSize int64  `config:"queueSize" default:"64" validate:"min=1"`
+}
+
+// GoalDispatchKeyFunc returns the key used to order the processing of
+// envelopes in pool mode; envelopes without a key are processed inline
+type GoalDispatchKeyFunc func(ctx context.Context) string
+
+// dispatcher runs the processing of envelopes; Dispatch blocks once the
+// queue of a session or worker is full, which stops the connection from
+// being read until there is room again
+type dispatcher interface {
+	Dispatch(ctx context.Context, task func())
+	Release(session GoalSession)
+	Close()
+}
+
+// taskQueue is a bounded queue of tasks run in order by a single goroutine
+type taskQueue struct {
+	tasks  chan func()
+	done   chan struct{}
+	closed bool
+	lock   sync.RWMutex
+}
+
+func newTaskQueue(size int) *taskQueue {
+	queue := &taskQueue{
+		tasks: make(chan func(), size),
+		done:  make(chan struct{}),
+	}
+
+	go queue.run()
+
+	return queue
+}
+
+// Push queues a task, waiting for room if the queue is full; it returns
+// false if the queue is closed
+func (queue *taskQueue) Push(task func()) bool {
+	queue.lock.RLock()
+	defer queue.lock.RUnlock()
+
+	if queue.closed {
+		return false
+	}
+
+	queue.tasks <- task
+
+	return true
+}
+
+// Close stops accepting tasks, and waits for queued tasks to be run
+func (queue *taskQueue) Close() {
+	queue.lock.Lock()
+	if !queue.closed {
+		queue.closed = true
+		close(queue.tasks)
+	}
+	queue.lock.Unlock()
+
+	<-queue.done
+}
+
+func (queue *taskQueue) run() {
+	defer close(queue.done)
+
+	for task := range queue.tasks {
+		task()
+	}
+}
+
+func newDispatcher(settings *servicesDispatchConfig, key GoalDispatchKeyFunc) dispatcher {
+	switch settings.Mode {
+	case SessionDispatch:
+		return &sessionDispatcher{
+			size:   int(settings.QueueSize),
+			queues: map[string]*taskQueue{},
+		}
+	case PoolDispatch:
+		workers := []*taskQueue{}
+		for i := int64(0); i < settings.Workers; i++ {
+			workers = append(workers, newTaskQueue(int(settings.QueueSize)))
+		}
+
+		return &poolDispatcher{
+			key:     key,
+			workers: workers,
+			pending: map[string]*sync.WaitGroup{},
+		}
+	default:
+		return inlineDispatcher{}
+	}
+}
+
+// sessionDispatchKey is the default dispatch key, keeping the envelopes
+// of each session in order
+func sessionDispatchKey(ctx context.Context) string {
+	session := GetSession(ctx)
+	if session == nil {
+		return ""
+	}
+
+	return session.GetID()
+}
+
+type inlineDispatcher struct{}
+
+func (inlineDispatcher) Dispatch(ctx context.Context, task func()) {
+	task()
+}
+
+func (inlineDispatcher) Release(session GoalSession) {}
+
+func (inlineDispatcher) Close() {}
+
+type sessionDispatcher struct {
+	size   int
+	queues map[string]*taskQueue
+	closed bool
+	lock   sync.Mutex
+}
+
+func (dispatcher *sessionDispatcher) Dispatch(ctx context.Context, task func()) {
+	session := GetSession(ctx)
+	if session == nil {
+		task()
+		return
+	}
+
+	dispatcher.lock.Lock()
+	queue := dispatcher.queues[session.GetID()]
+	if queue == nil && !dispatcher.closed {
+		queue = newTaskQueue(dispatcher.size)
+		dispatcher.queues[session.GetID()] = queue
+	}
+	dispatcher.lock.Unlock()
+
+	if queue == nil || !queue.Push(task) {
+		task()
+	}
+}
+
+// Release waits for the envelopes of a session to be processed, and
+// stops its goroutine
+func (dispatcher *sessionDispatcher) Release(session GoalSession) {
+	dispatcher.lock.Lock()
+	queue := dispatcher.queues[session.GetID()]
+	delete(dispatcher.queues, session.GetID())
+	dispatcher.lock.Unlock()
+
+	if queue != nil {
+		queue.Close()
+	}
+}
+
+func (dispatcher *sessionDispatcher) Close() {
+	dispatcher.lock.Lock()
+	dispatcher.closed = true
+	queues := dispatcher.queues
+	dispatcher.queues = map[string]*taskQueue{}
+	dispatcher.lock.Unlock()
+
+	for _, queue := range queues {
+		queue.Close()
+	}
+}
+
+type poolDispatcher struct {
+	key     GoalDispatchKeyFunc
+	workers []*taskQueue
+	pending map[string]*sync.WaitGroup
+	lock    sync.Mutex
+}
+
+func (dispatcher *poolDispatcher) Dispatch(ctx context.Context, task func()) {
+	worker := dispatcher.getWorker(ctx)
+	if worker == nil {
+		task()
+		return
+	}
+
+	// Tasks are counted per session, so that releasing a session
+	// does not wait for the ones of other sessions sharing its worker
+	session := GetSession(ctx)
+	if session != nil {
+		dispatcher.lock.Lock()
+		pending := dispatcher.pending[session.GetID()]
+		if pending == nil {
+			pending = &sync.WaitGroup{}
+			dispatcher.pending[session.GetID()] = pending
+		}
+		pending.Add(1)
+		dispatcher.lock.Unlock()
+
+		run := task
+		task = func() {
+			defer pending.Done()
+			run()
+		}
+	}
+
+	if !worker.Push(task) {
+		task()
+	}
+}
+
+// Release waits for the envelopes of a session which are already
+// queued to be processed
+func (dispatcher *poolDispatcher) Release(session GoalSession) {
+	dispatcher.lock.Lock()
+	pending := dispatcher.pending[session.GetID()]
+	delete(dispatcher.pending, session.GetID())
+	dispatcher.lock.Unlock()
+
+	if pending != nil {
+		pending.Wait()
+	}
+}
+
+func (dispatcher *poolDispatcher) getWorker(ctx context.Context) *taskQueue {
+	key := dispatcher.key(ctx)
+	if key == "" {
+		return nil
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+
+	return dispatcher.workers[hash.Sum32()%uint32(len(dispatcher.workers))]
+}
+
+func (dispatcher *poolDispatcher) Close() {
+	for _, worker := range dispatcher.workers {
+		worker.Close()
+	}
+}
@@ -0,0 +1,201 @@
+package systems_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+
+	. "github.com/Wizcorp/goal/src/proto"
+	. "github.com/Wizcorp/goal/src/systems"
+)
+
+type OrderedController struct {
+	PingControllerWithoutMessages
+	Received map[string][]int64
+	Running  int
+	Peak     int
+	Release  chan struct{}
+	lock     sync.Mutex
+}
+
+func (y *OrderedController) HandleGoalPingRequest(ctx context.Context, message *GoalPingRequest) {
+	y.lock.Lock()
+	y.Running++
+	if y.Running > y.Peak {
+		y.Peak = y.Running
+	}
+	y.lock.Unlock()
+
+	if y.Release != nil {
+		<-y.Release
+	} else {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	y.lock.Lock()
+	y.Running--
+	id := GetSession(ctx).GetID()
+	y.Received[id] = append(y.Received[id], message.Timestamp)
+	y.lock.Unlock()
+}
+
+func startDispatch(t *testing.T, controller *OrderedController, settings map[string]interface{}) GoalServices {
+	server := NewTestServer()
+	for key, value := range settings {
+		server.Config.Set("goal.services.dispatch."+key, value)
+	}
+
+	services := NewEmptyServices()
+	services.RegisterService(PingPathPrefix, NewPingServer(controller, nil), controller, nil)
+	server.RegisterSystem(1, "services", services)
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	t.Cleanup(func() {
+		server.Stop()
+	})
+
+	return services
+}
+
+func pingEnvelope(timestamp int64) []byte {
+	data, _ := ptypes.MarshalAny(&GoalPingRequest{Timestamp: timestamp})
+	envelope, _ := proto.Marshal(&GoalMessageEnvelope{
+		Messages: []*any.Any{data},
+	})
+
+	return envelope
+}
+
+func TestDispatchKeepsSessionsOrdered(t *testing.T) {
+	for _, mode := range []string{InlineDispatch, SessionDispatch, PoolDispatch} {
+		controller := &OrderedController{Received: map[string][]int64{}}
+		// Sessions are spread over workers by hash; with enough workers,
+		// all four of them landing on the same one is unlikely enough
+		services := startDispatch(t, controller, map[string]interface{}{
+			"mode":    mode,
+			"workers": 256,
+		})
+
+		sessions := []GoalSession{}
+		for i := 0; i < 4; i++ {
+			session := NewSession(&recordingConnection{}, "player", &ProtobufCodec{}, services.EmitMessages)
+			services.Connect(context.Background(), session)
+			sessions = append(sessions, session)
+		}
+
+		for timestamp := int64(0); timestamp < 5; timestamp++ {
+			for _, session := range sessions {
+				services.ProcessData(WithSession(context.Background(), session), pingEnvelope(timestamp))
+			}
+		}
+
+		for _, session := range sessions {
+			services.Disconnect(context.Background(), session)
+		}
+
+		for _, session := range sessions {
+			received := controller.Received[session.GetID()]
+			if len(received) != 5 {
+				t.Fatalf("%s: expected 5 messages, got %v", mode, received)
+			}
+
+			for i, timestamp := range received {
+				if timestamp != int64(i) {
+					t.Errorf("%s: messages were processed out of order: %v", mode, received)
+					break
+				}
+			}
+		}
+
+		if mode == InlineDispatch && controller.Peak != 1 {
+			t.Errorf("Inline dispatch processed messages concurrently")
+		}
+
+		if mode != InlineDispatch && controller.Peak < 2 {
+			t.Errorf("%s: sessions were not processed concurrently", mode)
+		}
+	}
+}
+
+func TestDispatchAppliesBackpressure(t *testing.T) {
+	controller := &OrderedController{
+		Received: map[string][]int64{},
+		Release:  make(chan struct{}),
+	}
+
+	services := startDispatch(t, controller, map[string]interface{}{
+		"mode":      PoolDispatch,
+		"workers":   1,
+		"queueSize": 1,
+	})
+
+	session := NewSession(&recordingConnection{}, "player", &ProtobufCodec{}, services.EmitMessages)
+	ctx := WithSession(context.Background(), session)
+
+	// The first envelope is being processed, the second one fills the queue
+	services.ProcessData(ctx, pingEnvelope(0))
+	services.ProcessData(ctx, pingEnvelope(1))
+
+	dispatched := make(chan struct{})
+	go func() {
+		services.ProcessData(ctx, pingEnvelope(2))
+		close(dispatched)
+	}()
+
+	select {
+	case <-dispatched:
+		t.Fatal("Envelope was dispatched to a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(controller.Release)
+	<-dispatched
+}
+
+func TestPoolReleaseWaitsForOwnEnvelopesOnly(t *testing.T) {
+	controller := &OrderedController{
+		Received: map[string][]int64{},
+		Release:  make(chan struct{}),
+	}
+
+	services := startDispatch(t, controller, map[string]interface{}{
+		"mode":    PoolDispatch,
+		"workers": 1,
+	})
+
+	busy := NewSession(&recordingConnection{}, "busy", &ProtobufCodec{}, services.EmitMessages)
+	idle := NewSession(&recordingConnection{}, "idle", &ProtobufCodec{}, services.EmitMessages)
+	services.Connect(context.Background(), busy)
+	services.Connect(context.Background(), idle)
+
+	// Both sessions share the only worker, which is busy with the first one
+	services.ProcessData(WithSession(context.Background(), busy), pingEnvelope(0))
+
+	released := make(chan struct{})
+	go func() {
+		services.Disconnect(context.Background(), idle)
+		close(released)
+	}()
+
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Error("Releasing a session waited for the envelopes of another one")
+	}
+
+	close(controller.Release)
+	services.Disconnect(context.Background(), busy)
+
+	if len(controller.Received[busy.GetID()]) != 1 {
+		t.Errorf("Envelope was not processed before releasing its session: %v", controller.Received)
+	}
+}
@@ -0,0 +1,91 @@
+package systems
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// GoalMessageHandlerFunc processes a message received on a message stream
+type GoalMessageHandlerFunc func(ctx context.Context, message proto.Message) error
+
+type GoalServiceHandler []GoalMessageHandlerFunc
+
+// GoalServiceWithMessageHandlers can be implemented by services to register
+// their message handlers with OnMessage; the Handle* methods of services
+// implementing it are not registered automatically
+type GoalServiceWithMessageHandlers interface {
+	RegisterMessageHandlers(services GoalServices)
+}
+
+// OnMessage registers a handler called with every received message of type T
+func OnMessage[T proto.Message](services GoalServices, handler func(ctx context.Context, message T) error) {
+	var message T
+	name := proto.MessageName(message)
+	if name == "" {
+		log.Panicf("Cannot register handler for %T, it is not a registered protobuf message", message)
+	}
+
+	services.AddMessageHandler(name, func(ctx context.Context, message proto.Message) error {
+		typed, ok := message.(T)
+		if !ok {
+			return NewMessageError(InvalidMessageErrorCode)
+		}
+
+		return handler(ctx, typed)
+	})
+}
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+var messageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// registerMethodHandlers registers the Handle* methods of a service, which
+// must be declared as HandleX(ctx, message) or HandleX(ctx, message) error,
+// message being a pointer to a concrete message type
+func (services *services) registerMethodHandlers(service GoalService) {
+	serviceType := reflect.TypeOf(service)
+	for i := 0; i < serviceType.NumMethod(); i++ {
+		method := serviceType.Method(i)
+		if !strings.HasPrefix(method.Name, "Handle") {
+			continue
+		}
+
+		methodType := method.Type
+		valid := methodType.NumIn() == 3 &&
+			methodType.In(1) == contextType &&
+			methodType.In(2).Kind() == reflect.Ptr &&
+			methodType.In(2).Implements(messageType) &&
+			(methodType.NumOut() == 0 || methodType.NumOut() == 1 && methodType.Out(0) == errorType)
+
+		if !valid {
+			log.Panicf("Method %s of %s must be declared as %s(context.Context, *Message) [error]", method.Name, serviceType, method.Name)
+		}
+
+		message := reflect.New(methodType.In(2)).Elem().Interface().(proto.Message)
+		name := proto.MessageName(message)
+		if name == "" {
+			log.Panicf("Method %s of %s handles %s, which is not a registered protobuf message", method.Name, serviceType, methodType.In(2))
+		}
+
+		services.AddMessageHandler(name, newMethodHandler(reflect.ValueOf(service).Method(i)))
+	}
+}
+
+func newMethodHandler(method reflect.Value) GoalMessageHandlerFunc {
+	return func(ctx context.Context, message proto.Message) error {
+		results := method.Call([]reflect.Value{
+			reflect.ValueOf(ctx),
+			reflect.ValueOf(message),
+		})
+
+		if len(results) == 1 && !results[0].IsNil() {
+			return results[0].Interface().(error)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,46 @@
+package systems
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type healthReport struct {
+	Status  Status                     `json:"status"`
+	Systems map[string]GoalSystemState `json:"systems"`
+}
+
+// NewHealthHandler creates an HTTP handler reporting the combined status of
+// all systems of a server.
+//
+// Liveness checks fail only when a system has failed, while readiness
+// checks fail as long as any system is not running.
+func NewHealthHandler(server GoalServer, readiness bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		states := server.GetSystemStates()
+		report := healthReport{
+			Status:  UpStatus,
+			Systems: states,
+		}
+
+		for _, state := range states {
+			switch {
+			case state.Status == FailedStatus:
+				report.Status = FailedStatus
+			case readiness && !state.Status.IsRunning() && report.Status != FailedStatus:
+				report.Status = DownStatus
+			case state.Status == DegradedStatus && report.Status == UpStatus:
+				report.Status = DegradedStatus
+			}
+		}
+
+		code := http.StatusOK
+		if !report.Status.IsRunning() {
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(report)
+	})
+}
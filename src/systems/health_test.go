@@ -0,0 +1,47 @@
+package systems_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/Wizcorp/goal/src/systems"
+)
+
+func TestHealthHandler(t *testing.T) {
+	events := &eventLog{}
+	server := NewTestServer()
+	system := newRecordingSystem("game", events, "logger").(*recordingSystemWithDependencies)
+	server.RegisterSystem(1, "game", system)
+
+	check := func(readiness bool, expected int) {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/", nil)
+		NewHealthHandler(server, readiness).ServeHTTP(recorder, request)
+
+		if recorder.Code != expected {
+			t.Errorf("Unexpected status code (readiness: %v): %d != %d (%s)", readiness, recorder.Code, expected, recorder.Body)
+		}
+	}
+
+	check(false, http.StatusOK)
+	check(true, http.StatusServiceUnavailable)
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	check(false, http.StatusOK)
+	check(true, http.StatusOK)
+
+	system.Status = FailedStatus
+
+	check(false, http.StatusServiceUnavailable)
+	check(true, http.StatusServiceUnavailable)
+
+	state := server.GetSystemStates()["game"]
+	if state.Status != FailedStatus {
+		t.Errorf("System state was not reported: %v", state.Status)
+	}
+}
@@ -3,18 +3,23 @@ package systems
 import (
 	"context"
 	"io"
+	"mime"
 	"net/http"
 	"path"
 	"time"
 
+	"github.com/go-errors/errors"
 	"github.com/gorilla/websocket"
-	"github.com/sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
 
 	. "github.com/Wizcorp/goal/src/api"
 )
 
 func init() {
-	RegisterSystem(5, "http", NewHTTP())
+	RegisterSystemFactory(5, "http", func() GoalSystem {
+		return NewHTTP()
+	})
+	RegisterConfigSchema("http", httpConfig{})
 }
 
 type GoalHTTP interface {
@@ -27,32 +32,58 @@ type GoalHTTP interface {
 type GoalMessageStreamConnection interface {
 	NextWriter(messageType int) (io.WriteCloser, error)
 	WriteMessage(messageType int, data []byte) error
+	Close() error
 }
 
 type httpServer struct {
-	Address  string
-	Prefix   string
-	Server   http.Server
-	Services GoalServices
-	Mux      *http.ServeMux
-	Logger   GoalLogger
+	GoalStatusTracker
+	Address          string
+	Prefix           string
+	Server           http.Server
+	Services         GoalServices
+	Auth             GoalAuth
+	Mux              *http.ServeMux
+	Logger           GoalLogger
+	settings         *httpConfig
+	upgrader         *websocket.Upgrader
+	sendQueueMetrics *sendQueueMetrics
+	panics           prometheus.Counter
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
+type httpConfig struct {
+	GoalSystemConfig
+	Prefix          string `config:"prefix" default:"/"`
+	Listen          string `config:"listen" default:"127.0.0.1:8080"`
+	Messages        string `config:"messages" default:"/messages"`
+	Health          string `config:"health" default:"/health"`
+	Ready           string `config:"ready" default:"/ready"`
+	ShutdownTimeout int64  `config:"shutdownTimeout" default:"10" validate:"min=0"`
+	SendQueue       struct {
+		Size     int64  `config:"size" default:"256" validate:"min=1"`
+		Overflow string `config:"overflow" default:"dropOldest" validate:"oneof=dropOldest|dropNewest|disconnect"`
+	} `config:"sendQueue"`
+	Stream httpStreamConfig `config:"stream"`
 }
 
 func NewHTTP() *httpServer {
 	return &httpServer{
-		Mux: http.NewServeMux(),
+		Mux:              http.NewServeMux(),
+		sendQueueMetrics: newSendQueueMetrics(),
+		panics:           newPanicCounter("http"),
 	}
 }
 
-func (httpServer *httpServer) Setup(server GoalServer, config *GoalConfig) error {
-	prefix := config.String("prefix", "/")
-	addr := config.String("listen", "127.0.0.1:8080")
-	messages := config.String("messages", "/messages")
+func (httpServer *httpServer) Setup(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	settings := &httpConfig{}
+	err := DecodeConfig(config, settings)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	prefix := settings.Prefix
+	addr := settings.Listen
+	httpServer.settings = settings
+	httpServer.upgrader = newUpgrader(&settings.Stream)
 
 	httpServer.Logger = (*server.GetSystem("logger")).(GoalLogger)
 	logger := httpServer.Logger.GetInstance()
@@ -61,37 +92,64 @@ func (httpServer *httpServer) Setup(server GoalServer, config *GoalConfig) error
 		"prefix":  prefix,
 	}).Info("Setting up HTTP Server system")
 
+	httpServer.Auth = (*server.GetSystem("auth")).(GoalAuth)
 	httpServer.Services = (*server.GetSystem("services")).(GoalServices)
 	for servicePath, service := range *httpServer.Services.GetServiceServers() {
 		logger.WithFields(LogFields{
 			"subpath": servicePath,
 		}).Debug("Exposing service")
 
-		httpServer.Handle(path.Join(prefix, servicePath)+"/", service)
+		httpServer.Handle(path.Join(prefix, servicePath)+"/", httpServer.authenticate(service))
 	}
 
-	httpServer.HandleFunc(path.Join(prefix, messages), httpServer.handleWebsocket)
+	httpServer.HandleFunc(path.Join(prefix, settings.Messages), httpServer.handleWebsocket)
+	httpServer.Handle(path.Join(prefix, settings.Health), NewHealthHandler(server, false))
+	httpServer.Handle(path.Join(prefix, settings.Ready), NewHealthHandler(server, true))
 
 	httpServer.Server = http.Server{
 		Addr:    addr,
 		Handler: httpServer,
 	}
 
-	go httpServer.Server.ListenAndServe()
+	httpServer.SetStatus(UpStatus, "")
+
+	go func() {
+		err := httpServer.Server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.WithFields(LogFields{
+				"address": addr,
+				"error":   err,
+			}).Error("HTTP server stopped unexpectedly")
+
+			httpServer.SetStatus(FailedStatus, err.Error())
+		}
+	}()
 
 	return nil
 }
 
-func (httpServer *httpServer) Teardown(server GoalServer, config *GoalConfig) error {
-	timeout := config.Int64("shutdownTimeout", 10)
-	ctx, cancel := context.WithTimeout(context.Background(), (time.Duration)(timeout)*time.Second)
+func (httpServer *httpServer) Teardown(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	settings := &httpConfig{}
+	err := DecodeConfig(config, settings)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	timeout := settings.ShutdownTimeout
+	ctx, cancel := context.WithTimeout(ctx, (time.Duration)(timeout)*time.Second)
 	defer cancel()
 
+	httpServer.SetStatus(DownStatus, "")
+
 	return httpServer.Server.Shutdown(ctx)
 }
 
-func (httpServer *httpServer) GetStatus() Status {
-	return UpStatus
+func (httpServer *httpServer) GetDependencies() []string {
+	return []string{"logger", "auth", "services"}
+}
+
+func (httpServer *httpServer) GetCollectors() []prometheus.Collector {
+	return append(httpServer.sendQueueMetrics.Collectors(), httpServer.panics)
 }
 
 func (httpServer *httpServer) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
@@ -102,69 +160,190 @@ func (httpServer *httpServer) Handle(pattern string, handler http.Handler) {
 	httpServer.Mux.Handle(pattern, handler)
 }
 
+// authenticate wraps a Twirp service so that every call is authenticated,
+// and the identity of the caller is available from the call's context
+func (httpServer *httpServer) authenticate(service http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := httpServer.Auth.Authenticate(r)
+		if err != nil {
+			httpServer.Logger.GetInstance().WithFields(LogFields{
+				"remote": r.RemoteAddr,
+				"path":   r.URL.Path,
+				"error":  err,
+			}).Info("Refused unauthenticated service call")
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code":"unauthenticated","msg":"unauthenticated"}`))
+			return
+		}
+
+		if identity != nil {
+			r = r.WithContext(WithIdentity(r.Context(), identity))
+		}
+
+		service.ServeHTTP(w, r)
+	})
+}
+
 func (httpServer *httpServer) handleWebsocket(w http.ResponseWriter, r *http.Request) {
-	contentTypes := r.Header["Content-Type"]
-	conn, err := upgrader.Upgrade(w, r, nil)
 	logger := httpServer.Logger.GetInstance()
 
+	identity, err := httpServer.Auth.Authenticate(r)
 	if err != nil {
 		logger.WithFields(LogFields{
-			"error": err,
-		}).Warn("Error during upgrade to WebSocket protocol")
+			"remote": r.RemoteAddr,
+			"error":  err,
+		}).Info("Refused unauthenticated message stream")
 
+		http.Error(w, "Unauthenticated", http.StatusUnauthorized)
 		return
 	}
 
-	var contentType string
-	if len(contentTypes) == 0 {
-		contentType = "application/json"
-	} else {
-		contentType = contentTypes[0]
+	codec, subprotocol, err := negotiateCodec(r)
+	if err != nil {
+		logger.WithFields(LogFields{
+			"remote": r.RemoteAddr,
+			"error":  err,
+		}).Warn("Attempting to create message stream with an unsupported encoding")
+
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
 	}
 
-	switch contentType {
-	case "application/json":
-		go httpServer.processJSONMessages(conn)
-	case "application/protobuf":
-		go httpServer.processProtobufMessages(conn)
-	default:
+	responseHeader := http.Header{}
+	if subprotocol != "" {
+		responseHeader.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+
+	conn, err := httpServer.upgrader.Upgrade(w, r, responseHeader)
+
+	if err != nil {
 		logger.WithFields(LogFields{
-			"remote":       conn.RemoteAddr().Network(),
-			"content-type": contentType,
-		}).Warn("Attempting to create message stream with invalid content type")
-		conn.WriteMessage(0, []byte("Invalid Content-Type header"))
-		conn.Close()
+			"error": err,
+		}).Warn("Error during upgrade to WebSocket protocol")
+
+		return
 	}
 
+	go httpServer.serveSession(conn, identity, codec)
 }
 
-func (httpServer *httpServer) processJSONMessages(conn *websocket.Conn) {
-	logger := httpServer.Logger.GetInstance()
-	ctx := createContext(conn, httpServer.Services.EmitJSONMessages)
-
-	for {
-		data, err := readConnectionData(conn, logger)
+// EncodingQueryParameter can be used by clients which can set neither
+// the subprotocol nor the Content-Type of the upgrade request; it accepts
+// a media type or a subprotocol name
+const EncodingQueryParameter = "encoding"
+
+// negotiateCodec selects the codec of a message stream from, in order, the
+// WebSocket subprotocols offered by the client, the Content-Type header of
+// the upgrade request and its encoding query parameter; JSON is used when
+// the client expresses no supported preference. The subprotocol to echo back
+// to the client is returned along with the codec
+func negotiateCodec(r *http.Request) (GoalCodec, string, error) {
+	subprotocols := websocket.Subprotocols(r)
+	for _, subprotocol := range subprotocols {
+		codec, found := GetCodecBySubprotocol(subprotocol)
+		if found {
+			return codec, subprotocol, nil
+		}
+	}
 
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
 		if err != nil {
-			// Todo: send error message before closing
-			conn.Close()
-			break
+			return nil, "", errors.Wrap(err, 0)
 		}
 
-		httpServer.Services.ProcessJSONMessages(ctx, *data)
+		codec, found := GetCodec(mediaType)
+		if !found {
+			return nil, "", errors.Errorf("Unsupported Content-Type %s", contentType)
+		}
+
+		return codec, "", nil
 	}
+
+	encoding := r.URL.Query().Get(EncodingQueryParameter)
+	if encoding != "" {
+		codec, found := GetCodec(encoding)
+		if !found {
+			codec, found = GetCodecBySubprotocol(encoding)
+		}
+
+		if !found {
+			return nil, "", errors.Errorf("Unsupported encoding %s", encoding)
+		}
+
+		return codec, "", nil
+	}
+
+	// Unknown subprotocols are not echoed back, leaving it to the client
+	// to decide whether to keep a connection which uses JSON
+	codec, _ := GetCodec("application/json")
+
+	return codec, "", nil
 }
 
-func (httpServer *httpServer) processProtobufMessages(conn *websocket.Conn) {
+// serveSession reads messages from a connection until it is closed, and
+// lets services know when the session starts and ends
+func (httpServer *httpServer) serveSession(conn *websocket.Conn, identity *GoalIdentity, codec GoalCodec) {
 	logger := httpServer.Logger.GetInstance()
-	ctx := createContext(conn, httpServer.Services.EmitProtobufMessages)
+
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+
+		httpServer.panics.Inc()
+		logPanic(httpServer.Logger, LogFields{
+			"remote": conn.RemoteAddr().String(),
+		}, recovered, "Recovered from panic in message stream connection")
+
+		conn.Close()
+	}()
+
+	queue := newSendQueue(
+		conn,
+		int(httpServer.settings.SendQueue.Size),
+		httpServer.settings.SendQueue.Overflow,
+		httpServer.settings.Stream.WriteTimeout,
+		httpServer.sendQueueMetrics,
+	)
+
+	session := NewSession(queue, conn.RemoteAddr().String(), codec, httpServer.Services.EmitMessages)
+	ctx := WithSession(context.Background(), session)
+
+	// Closing the session stops its send queue, including after a panic
+	defer session.Close()
+
+	if identity != nil {
+		session.Set(IdentitySessionAttribute, identity)
+	}
+
+	err := httpServer.Services.Connect(ctx, session)
+	if err != nil {
+		logger.WithFields(LogFields{
+			"session": session.GetID(),
+			"remote":  session.GetRemoteAddress(),
+			"error":   err,
+		}).Info("Message stream session was refused")
+		return
+	}
+
+	watch := newStreamWatch(conn, &httpServer.settings.Stream)
+	go watch.Run(func() {
+		session.Close()
+	})
+
+	defer watch.Stop()
+	defer httpServer.Services.Disconnect(ctx, session)
 
 	for {
-		data, err := readConnectionData(conn, logger)
+		data, err := readConnectionData(conn)
 
 		if err != nil {
-			// Todo: send error message before closing
-			conn.Close()
+			httpServer.logReadError(session, watch, err)
 			break
 		}
 
@@ -172,15 +351,46 @@ func (httpServer *httpServer) processProtobufMessages(conn *websocket.Conn) {
 			break
 		}
 
-		httpServer.Services.ProcessProtobufMessages(ctx, *data)
+		watch.Touch()
+		httpServer.Services.ProcessData(ctx, *data)
 	}
 }
 
+// logReadError reports why a message stream stopped, and lets the client
+// know when the connection is closed because it broke a limit
+func (httpServer *httpServer) logReadError(session GoalSession, watch *streamWatch, err error) {
+	logger := httpServer.Logger.GetInstance().WithFields(LogFields{
+		"session": session.GetID(),
+		"remote":  session.GetRemoteAddress(),
+	})
+
+	reason := watch.Reason()
+
+	switch {
+	case reason != "":
+	case err == websocket.ErrReadLimit:
+		// The close frame is sent by the websocket library itself
+		reason = "message too big"
+	case isTimeout(err):
+		reason = "heartbeat timeout"
+		watch.Close(websocket.CloseGoingAway, reason)
+	default:
+		logger.WithFields(LogFields{
+			"error": err,
+		}).Error("Unexpected message stream read error")
+		return
+	}
+
+	logger.WithFields(LogFields{
+		"reason": reason,
+	}).Info("Closed message stream")
+}
+
 func (httpServer *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	httpServer.Mux.ServeHTTP(w, r)
 }
 
-func readConnectionData(conn *websocket.Conn, logger *logrus.Logger) (*[]byte, error) {
+func readConnectionData(conn *websocket.Conn) (*[]byte, error) {
 	_, data, err := conn.ReadMessage()
 
 	if err != nil {
@@ -193,21 +403,8 @@ func readConnectionData(conn *websocket.Conn, logger *logrus.Logger) (*[]byte, e
 			}
 		}
 
-		logger.WithFields(LogFields{
-			"remote": conn.RemoteAddr().Network(),
-			"error":  err,
-		}).Error("Unexpected message stream read error")
-
 		return nil, err
 	}
 
 	return &data, nil
 }
-
-func createContext(conn *websocket.Conn, emitter GoalServiceEmitter) context.Context {
-	ctx := context.Background()
-	ctx = context.WithValue(ctx, "conn", conn)
-	ctx = context.WithValue(ctx, "emitter", emitter)
-
-	return ctx
-}
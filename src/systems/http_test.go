@@ -0,0 +1,338 @@
+package systems_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/gorilla/websocket"
+
+	. "github.com/Wizcorp/goal/src/proto"
+	. "github.com/Wizcorp/goal/src/systems"
+)
+
+// startHTTP starts a server exposing the given service, and returns
+// the URL of its message stream endpoint
+func startHTTP(t *testing.T, controller Ping, settings map[string]interface{}) string {
+	server := NewTestServer()
+	server.Config.Set("goal.http.listen", "127.0.0.1:0")
+	for key, value := range settings {
+		server.Config.Set("goal.http."+key, value)
+	}
+
+	services := NewEmptyServices()
+	services.RegisterService(PingPathPrefix, NewPingServer(controller, nil), controller, nil)
+
+	server.RegisterSystem(1, "auth", NewAuth())
+	server.RegisterSystem(2, "services", services)
+	server.RegisterSystem(3, "http", NewHTTP())
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	httpServer := httptest.NewServer((*server.GetSystem("http")).(GoalHTTP))
+	t.Cleanup(func() {
+		httpServer.Close()
+		server.Stop()
+	})
+
+	return "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/messages"
+}
+
+func sendPing(t *testing.T, conn *websocket.Conn, id int32) {
+	data, _ := ptypes.MarshalAny(&GoalPingRequest{Timestamp: 1})
+	envelope, _ := proto.Marshal(&GoalMessageEnvelope{
+		Id:       id,
+		Messages: []*any.Any{data},
+	})
+
+	err := conn.WriteMessage(websocket.BinaryMessage, envelope)
+	if err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+}
+
+type FloodController struct {
+	PingControllerWithoutMessages
+}
+
+func (y *FloodController) HandleGoalPingRequest(ctx context.Context, message *GoalPingRequest) {
+	session := GetSession(ctx)
+	group := sync.WaitGroup{}
+
+	for i := 0; i < 8; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for j := 0; j < 50; j++ {
+				session.Emit(ctx, &GoalPingResponse{Timestamp: message.Timestamp})
+			}
+		}()
+	}
+
+	group.Wait()
+}
+
+func TestConcurrentEmitsAreSerialized(t *testing.T) {
+	url := startHTTP(t, &FloodController{}, map[string]interface{}{
+		"sendQueue.size": 1000,
+	})
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/protobuf")
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	defer conn.Close()
+	sendPing(t, conn, 1)
+
+	for i := 0; i < 400; i++ {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read message %d: %v", i, err)
+		}
+
+		var envelope GoalMessageEnvelope
+		err = proto.Unmarshal(data, &envelope)
+		if err != nil || envelope.Id != 1 {
+			t.Fatalf("Corrupted message %d: %v", i, err)
+		}
+	}
+}
+
+func TestStreamLimitsCloseConnections(t *testing.T) {
+	url := startHTTP(t, &PingController{}, map[string]interface{}{
+		"stream.idleTimeout":    "200ms",
+		"stream.maxMessageSize": 64,
+		"stream.allowedOrigins": []interface{}{"http://game.example"},
+	})
+
+	expectClose := func(conn *websocket.Conn, code int) {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		_, _, err := conn.ReadMessage()
+		if !websocket.IsCloseError(err, code) {
+			t.Errorf("Connection was not closed with code %d: %v", code, err)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Origin", "http://game.example")
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	defer conn.Close()
+	conn.WriteMessage(websocket.BinaryMessage, make([]byte, 128))
+	expectClose(conn, websocket.CloseMessageTooBig)
+
+	idleConn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	defer idleConn.Close()
+	expectClose(idleConn, websocket.CloseGoingAway)
+
+	header.Set("Origin", "http://evil.example")
+	_, response, err := websocket.DefaultDialer.Dial(url, header)
+	if err == nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Connection from a disallowed origin was accepted: %v", err)
+	}
+}
+
+func TestUnresponsiveClientsAreDisconnected(t *testing.T) {
+	url := startHTTP(t, &PingController{}, map[string]interface{}{
+		"stream.pingInterval": "100ms",
+		"stream.pongTimeout":  "100ms",
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	defer conn.Close()
+
+	// Pings are only answered while reading
+	time.Sleep(500 * time.Millisecond)
+
+	// Answering queued pings may fail once the server closed the connection
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if netErr, ok := err.(net.Error); err == nil || ok && netErr.Timeout() {
+		t.Errorf("Unresponsive client was not disconnected: %v", err)
+	}
+}
+
+type PanickingConnectController struct {
+	PingControllerWithoutMessages
+	closed chan struct{}
+}
+
+func (y *PanickingConnectController) OnConnect(ctx context.Context, session GoalSession) error {
+	session.OnClose(func() {
+		close(y.closed)
+	})
+
+	panic("connect failed")
+}
+
+func TestSessionsAreClosedWhenConnectPanics(t *testing.T) {
+	controller := &PanickingConnectController{closed: make(chan struct{})}
+	url := startHTTP(t, controller, nil)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	defer conn.Close()
+
+	select {
+	case <-controller.closed:
+	case <-time.After(time.Second):
+		t.Error("Session was not closed after a panic in OnConnect")
+	}
+}
+
+type TaggedCodec struct {
+	ProtobufCodec
+}
+
+func (codec *TaggedCodec) GetMediaType() string {
+	return "application/x-tagged"
+}
+
+func (codec *TaggedCodec) GetSubprotocol() string {
+	return "goal.test.tagged"
+}
+
+func (codec *TaggedCodec) Marshal(envelope *GoalMessageEnvelope) ([]byte, error) {
+	data, err := codec.ProtobufCodec.Marshal(envelope)
+
+	return append([]byte("tag:"), data...), err
+}
+
+func (codec *TaggedCodec) Unmarshal(data []byte, envelope *GoalMessageEnvelope) error {
+	return codec.ProtobufCodec.Unmarshal(bytes.TrimPrefix(data, []byte("tag:")), envelope)
+}
+
+func TestRegisteredCodecsCanBeNegotiated(t *testing.T) {
+	RegisterCodec(&TaggedCodec{})
+	t.Cleanup(func() {
+		UnregisterCodec("application/x-tagged")
+	})
+
+	url := startHTTP(t, &EchoController{}, nil)
+
+	dialer := websocket.Dialer{Subprotocols: []string{"goal.test.tagged"}}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+
+	defer conn.Close()
+
+	if conn.Subprotocol() != "goal.test.tagged" {
+		t.Errorf("Subprotocol was not echoed: %q", conn.Subprotocol())
+	}
+
+	data, _ := ptypes.MarshalAny(&GoalPingRequest{Timestamp: 1})
+	envelope, _ := proto.Marshal(&GoalMessageEnvelope{
+		Id:       4,
+		Messages: []*any.Any{data},
+	})
+
+	conn.WriteMessage(websocket.BinaryMessage, append([]byte("tag:"), envelope...))
+
+	_, reply, err := conn.ReadMessage()
+	if err != nil || !bytes.HasPrefix(reply, []byte("tag:")) {
+		t.Fatalf("Reply was not encoded by the negotiated codec: %v", err)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-unknown")
+
+	_, response, err := websocket.DefaultDialer.Dial(url, header)
+	if err == nil || response.StatusCode != http.StatusUnsupportedMediaType {
+		t.Error("Unknown content type was accepted")
+	}
+}
+
+func TestMessageStreamEncodingNegotiation(t *testing.T) {
+	url := startHTTP(t, &EchoController{}, nil)
+
+	contentType := http.Header{}
+	contentType.Set("Content-Type", "application/protobuf")
+
+	cases := []struct {
+		name         string
+		query        string
+		header       http.Header
+		subprotocols []string
+		subprotocol  string
+		codec        GoalCodec
+	}{
+		{"proto subprotocol", "", nil, []string{"chat", "goal.v1.proto"}, "goal.v1.proto", &ProtobufCodec{}},
+		{"json subprotocol", "", nil, []string{"goal.v1.json"}, "goal.v1.json", &JSONCodec{}},
+		{"unknown subprotocol", "", nil, []string{"chat"}, "", &JSONCodec{}},
+		{"content type", "", contentType, nil, "", &ProtobufCodec{}},
+		{"query parameter", "?encoding=goal.v1.proto", nil, nil, "", &ProtobufCodec{}},
+		{"no preference", "", nil, nil, "", &JSONCodec{}},
+	}
+
+	for _, c := range cases {
+		dialer := websocket.Dialer{Subprotocols: c.subprotocols}
+		conn, _, err := dialer.Dial(url+c.query, c.header)
+		if err != nil {
+			t.Fatalf("%s: failed to connect: %v", c.name, err)
+		}
+
+		if conn.Subprotocol() != c.subprotocol {
+			t.Errorf("%s: expected subprotocol %q, got %q", c.name, c.subprotocol, conn.Subprotocol())
+		}
+
+		data, _ := ptypes.MarshalAny(&GoalPingRequest{Timestamp: 1})
+		envelope, _ := c.codec.Marshal(&GoalMessageEnvelope{
+			Id:       2,
+			Messages: []*any.Any{data},
+		})
+
+		conn.WriteMessage(c.codec.GetMessageType(), envelope)
+
+		messageType, reply, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("%s: failed to read reply: %v", c.name, err)
+		}
+
+		var decoded GoalMessageEnvelope
+		err = c.codec.Unmarshal(reply, &decoded)
+		if err != nil || messageType != c.codec.GetMessageType() || decoded.Id != 2 {
+			t.Errorf("%s: reply was not encoded with %s: %v", c.name, c.codec.GetMediaType(), err)
+		}
+
+		conn.Close()
+	}
+
+	_, response, err := websocket.DefaultDialer.Dial(url+"?encoding=application/x-unknown", nil)
+	if err == nil || response.StatusCode != http.StatusUnsupportedMediaType {
+		t.Error("Unknown encoding was accepted")
+	}
+}
@@ -1,6 +1,7 @@
 package systems
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -11,7 +12,10 @@ import (
 )
 
 func init() {
-	RegisterSystem(0, "logger", NewLogger())
+	RegisterSystemFactory(0, "logger", func() GoalSystem {
+		return NewLogger()
+	})
+	RegisterConfigSchema("logger", loggerConfig{})
 }
 
 type GoalLogger interface {
@@ -20,46 +24,47 @@ type GoalLogger interface {
 }
 
 type logger struct {
+	GoalStatusTracker
 	Instance *logrus.Logger
 }
 
 type LogFields = logrus.Fields
 
+type loggerConfig struct {
+	GoalSystemConfig
+	Format       string `config:"format" default:"text" validate:"oneof=text|json"`
+	Level        string `config:"level" default:"info" validate:"oneof=trace|debug|info|warn|error|fatal|panic"`
+	ReportCaller bool   `config:"reportCaller"`
+}
+
+// secretsHook redacts secrets found in the fields of log entries
+type secretsHook struct{}
+
 func NewLogger() *logger {
+	instance := logrus.New()
+	instance.AddHook(&secretsHook{})
+
 	return &logger{
-		Instance: logrus.New(),
+		Instance: instance,
 	}
 }
 
-func (logger *logger) Setup(server GoalServer, config *GoalConfig) error {
+func (logger *logger) Setup(ctx context.Context, server GoalServer, config *GoalConfig) error {
 	instance := logger.Instance
 
 	forceColors := os.Getenv("COLORS") == "true"
-	format := config.String("format", "text")
-	reportCaller := config.Bool("reportCaller", false)
-	level, err := getConfigLevel(config)
 
+	settings, err := logger.configure(config)
 	if err != nil {
 		return errors.Wrap(err, 0)
 	}
 
-	if format == "json" {
-		instance.SetFormatter(&logrus.JSONFormatter{})
-	} else {
-		instance.SetFormatter(&logrus.TextFormatter{
-			ForceColors: forceColors,
-		})
-	}
-
-	instance.SetLevel(level)
-	instance.SetReportCaller(reportCaller)
-
 	instance.WithFields(LogFields{
-		"format":      format,
+		"format":      settings.Format,
 		"forceColors": forceColors,
 	}).Info("Logger system set")
 
-	if format == "text" {
+	if settings.Format == "text" {
 		instance.Debug("                        ___")
 		instance.Debug("    o__        o__     |   |\\")
 		instance.Debug("   /|          /\\      |   |X\\")
@@ -67,26 +72,83 @@ func (logger *logger) Setup(server GoalServer, config *GoalConfig) error {
 		instance.Debug("                       GOAL//NG")
 	}
 
+	logger.SetStatus(UpStatus, "")
+
 	return nil
 }
 
-func (logger *logger) Teardown(server GoalServer, config *GoalConfig) error {
+func (logger *logger) Teardown(ctx context.Context, server GoalServer, config *GoalConfig) error {
 	logger.Instance.Info("Tearing down logger system")
+	logger.SetStatus(DownStatus, "")
+
+	return nil
+}
+
+func (logger *logger) Reconfigure(server GoalServer, oldConfig *GoalConfig, newConfig *GoalConfig) error {
+	settings, err := logger.configure(newConfig)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	logger.Instance.WithFields(LogFields{
+		"level":  settings.Level,
+		"format": settings.Format,
+	}).Info("Logger system reconfigured")
 
 	return nil
 }
 
-func (logger *logger) GetStatus() Status {
-	return UpStatus
+func (logger *logger) GetDependencies() []string {
+	return []string{}
 }
 
 func (logger *logger) GetInstance() *logrus.Logger {
 	return logger.Instance
 }
 
-func getConfigLevel(config *GoalConfig) (logrus.Level, error) {
-	level := config.String("level", "info")
+func (logger *logger) configure(config *GoalConfig) (*loggerConfig, error) {
+	instance := logger.Instance
+	settings := &loggerConfig{}
+
+	err := DecodeConfig(config, settings)
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	forceColors := os.Getenv("COLORS") == "true"
+	level, err := getConfigLevel(settings.Level)
+
+	if err != nil {
+		return nil, errors.Wrap(err, 0)
+	}
+
+	if settings.Format == "json" {
+		instance.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		instance.SetFormatter(&logrus.TextFormatter{
+			ForceColors: forceColors,
+		})
+	}
+
+	instance.SetLevel(level)
+	instance.SetReportCaller(settings.ReportCaller)
+
+	return settings, nil
+}
+
+func (hook *secretsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (hook *secretsHook) Fire(entry *logrus.Entry) error {
+	for key, value := range entry.Data {
+		entry.Data[key] = RedactSecrets(value)
+	}
+
+	return nil
+}
 
+func getConfigLevel(level string) (logrus.Level, error) {
 	switch level {
 	case "trace":
 		return logrus.TraceLevel, nil
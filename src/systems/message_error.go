@@ -0,0 +1,96 @@
+package systems
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+
+	. "github.com/Wizcorp/goal/src/proto"
+)
+
+// Codes of the GoalError replies sent by the framework itself
+const (
+	InvalidEnvelopeErrorCode = "invalid_envelope"
+	InvalidMessageErrorCode  = "invalid_message"
+	UnknownMessageErrorCode  = "unknown_message"
+	InternalErrorCode        = "internal"
+)
+
+// GoalMessageError can be returned by message handlers to reply with
+// a GoalError carrying a specific code and details; any other error
+// is reported to the client with the internal error code
+type GoalMessageError struct {
+	Code    string
+	Details []proto.Message
+}
+
+func NewMessageError(code string, details ...proto.Message) *GoalMessageError {
+	return &GoalMessageError{
+		Code:    code,
+		Details: details,
+	}
+}
+
+func (err *GoalMessageError) Error() string {
+	return "message error: " + err.Code
+}
+
+// emitError sends a GoalError tied to the envelope being processed
+// to the session found in the context
+func (services *services) emitError(ctx context.Context, err error) {
+	logger := services.Logger.GetInstance()
+	id := GetMessageID(ctx)
+
+	messageError, ok := err.(*GoalMessageError)
+	if !ok {
+		logger.WithFields(LogFields{
+			"id":    id,
+			"error": err,
+		}).Error("Message handler failed")
+
+		messageError = NewMessageError(InternalErrorCode)
+	}
+
+	details := []*any.Any{}
+	for _, detail := range messageError.Details {
+		anyDetail, isAny := detail.(*any.Any)
+		if !isAny {
+			var marshalErr error
+			anyDetail, marshalErr = ptypes.MarshalAny(detail)
+			if marshalErr != nil {
+				logger.WithFields(LogFields{
+					"code":  messageError.Code,
+					"error": marshalErr,
+				}).Warn("Error detail could not be serialized, skipping")
+				continue
+			}
+		}
+
+		details = append(details, anyDetail)
+	}
+
+	session := GetSession(ctx)
+	if session == nil {
+		logger.WithFields(LogFields{
+			"id":   id,
+			"code": messageError.Code,
+		}).Warn("No session found to send error reply")
+		return
+	}
+
+	err = session.Emit(ctx, &GoalError{
+		Id:      id,
+		Code:    messageError.Code,
+		Details: details,
+	})
+
+	if err != nil {
+		logger.WithFields(LogFields{
+			"id":    id,
+			"code":  messageError.Code,
+			"error": err,
+		}).Warn("Error reply could not be sent")
+	}
+}
@@ -1,6 +1,9 @@
 package systems
 
 import (
+	"context"
+
+	"github.com/go-errors/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
@@ -8,7 +11,10 @@ import (
 )
 
 func init() {
-	RegisterSystem(1, "metrics", NewMetrics())
+	RegisterSystemFactory(1, "metrics", func() GoalSystem {
+		return NewMetrics()
+	})
+	RegisterConfigSchema("metrics", metricsConfig{})
 }
 
 type GoalMetrics interface {
@@ -18,22 +24,38 @@ type GoalMetrics interface {
 	RegisterHistogram(name string, help string) prometheus.Histogram
 }
 
+// GoalSystemWithMetrics can be implemented by systems which create their own
+// collectors; they are registered when the metrics system is set up
+type GoalSystemWithMetrics interface {
+	GetCollectors() []prometheus.Collector
+}
+
+type metricsConfig struct {
+	GoalSystemConfig
+	Path string `config:"path" default:"/metrics"`
+}
+
 type metrics struct {
-	Status   Status
+	GoalStatusTracker
 	prefix   string
 	registry *prometheus.Registry
 }
 
 func NewMetrics() *metrics {
 	return &metrics{
-		Status:   DownStatus,
 		prefix:   "goal_",
 		registry: prometheus.NewRegistry(),
 	}
 }
 
-func (metrics *metrics) Setup(server GoalServer, config *GoalConfig) error {
-	metricsPath := config.String("path", "/metrics")
+func (metrics *metrics) Setup(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	settings := &metricsConfig{}
+	err := DecodeConfig(config, settings)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	metricsPath := settings.Path
 
 	logger := (*server.GetSystem("logger")).(GoalLogger).GetInstance()
 	logger.WithFields(LogFields{
@@ -48,6 +70,14 @@ func (metrics *metrics) Setup(server GoalServer, config *GoalConfig) error {
 	processCollector := prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{})
 	registry.Register(processCollector)
 
+	for name := range server.GetSystemStates() {
+		if system, ok := (*server.GetSystem(name)).(GoalSystemWithMetrics); ok {
+			for _, collector := range system.GetCollectors() {
+				registry.Register(collector)
+			}
+		}
+	}
+
 	handler := promhttp.InstrumentMetricHandler(
 		registry,
 		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
@@ -56,21 +86,21 @@ func (metrics *metrics) Setup(server GoalServer, config *GoalConfig) error {
 	http := (*server.GetSystem("http")).(GoalHTTP)
 	http.Handle(metricsPath, handler)
 
-	metrics.Status = UpStatus
+	metrics.SetStatus(UpStatus, "")
 
 	return nil
 }
 
-func (metrics *metrics) Teardown(server GoalServer, config *GoalConfig) error {
+func (metrics *metrics) Teardown(ctx context.Context, server GoalServer, config *GoalConfig) error {
 	logger := (*server.GetSystem("logger")).(GoalLogger).GetInstance()
 	logger.Info("Tearing down metrics system")
-	metrics.Status = DownStatus
+	metrics.SetStatus(DownStatus, "")
 
 	return nil
 }
 
-func (metrics *metrics) GetStatus() Status {
-	return UpStatus
+func (metrics *metrics) GetDependencies() []string {
+	return []string{"logger", "http"}
 }
 
 func (metrics *metrics) RegisterCounter(name string, help string) prometheus.Counter {
@@ -0,0 +1,47 @@
+package systems
+
+// GoalMessageMiddleware wraps the dispatch of messages received on message
+// streams; it may act before and after calling next, or not call it at all
+type GoalMessageMiddleware func(next GoalMessageHandlerFunc) GoalMessageHandlerFunc
+
+// GoalServiceWithMessageMiddleware can be implemented by services whose
+// message handlers must be wrapped by middleware; it only applies to
+// the handlers of the service
+type GoalServiceWithMessageMiddleware interface {
+	GetMessageMiddleware() []GoalMessageMiddleware
+}
+
+var defaultMiddleware = []GoalMessageMiddleware{}
+
+// RegisterMessageMiddleware adds middleware wrapping the dispatch of every
+// message on every services system created with NewServices
+func RegisterMessageMiddleware(middleware ...GoalMessageMiddleware) {
+	defaultMiddleware = append(defaultMiddleware, middleware...)
+}
+
+// Use adds middleware wrapping the dispatch of every message on this
+// services system; middleware added first is called first. It is safe
+// to call while the server is running: messages already being dispatched
+// finish going through the previous chain
+func (services *services) Use(middleware ...GoalMessageMiddleware) {
+	services.lock.Lock()
+	defer services.lock.Unlock()
+
+	services.Middleware = append(services.Middleware, middleware...)
+	services.dispatch = chainMiddleware(services.processMessage, services.Middleware)
+}
+
+func (services *services) getDispatch() GoalMessageHandlerFunc {
+	services.lock.RLock()
+	defer services.lock.RUnlock()
+
+	return services.dispatch
+}
+
+func chainMiddleware(handler GoalMessageHandlerFunc, middleware []GoalMessageMiddleware) GoalMessageHandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
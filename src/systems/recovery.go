@@ -0,0 +1,26 @@
+package systems
+
+import (
+	"github.com/go-errors/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newPanicCounter(subsystem string) prometheus.Counter {
+	return prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "goal",
+		Subsystem: subsystem,
+		Name:      "panics_total",
+		Help:      "Number of panics recovered from",
+	})
+}
+
+// logPanic logs a value returned by recover, along with the stack
+// of the goroutine which panicked
+func logPanic(logger GoalLogger, fields LogFields, recovered interface{}, message string) {
+	err := errors.Wrap(recovered, 2)
+
+	fields["error"] = err.Error()
+	fields["stack"] = string(err.Stack())
+
+	logger.GetInstance().WithFields(fields).Error(message)
+}
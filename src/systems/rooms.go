@@ -0,0 +1,196 @@
+package systems
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+
+	. "github.com/Wizcorp/goal/src/api"
+)
+
+func init() {
+	RegisterSystemFactory(4, "rooms", func() GoalSystem {
+		return NewRooms()
+	})
+	RegisterConfigSchema("rooms", GoalSystemConfig{})
+}
+
+// GoalRooms groups sessions so that messages can be pushed to all of them
+type GoalRooms interface {
+	GoalSystem
+	Join(room string, session GoalSession)
+	Leave(room string, session GoalSession)
+	LeaveAll(session GoalSession)
+	GetMembers(room string) []GoalSession
+	GetRooms(session GoalSession) []string
+	Broadcast(ctx context.Context, room string, messages ...proto.Message) error
+}
+
+// GoalBroadcastError lists the sessions, by ID, to which a broadcast
+// could not be delivered
+type GoalBroadcastError map[string]error
+
+func (broadcastError GoalBroadcastError) Error() string {
+	ids := []string{}
+	for id := range broadcastError {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	messages := []string{}
+	for _, id := range ids {
+		messages = append(messages, fmt.Sprintf("%s: %v", id, broadcastError[id]))
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+type rooms struct {
+	GoalStatusTracker
+	rooms       map[string]map[string]GoalSession
+	memberships map[string]map[string]bool
+	closeHooks  map[string]bool
+	lock        sync.RWMutex
+}
+
+func NewRooms() *rooms {
+	return &rooms{
+		rooms:       map[string]map[string]GoalSession{},
+		memberships: map[string]map[string]bool{},
+		closeHooks:  map[string]bool{},
+	}
+}
+
+func (rooms *rooms) Setup(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	logger := (*server.GetSystem("logger")).(GoalLogger).GetInstance()
+	logger.Info("Setting up rooms system")
+	rooms.SetStatus(UpStatus, "")
+
+	return nil
+}
+
+func (rooms *rooms) Teardown(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	logger := (*server.GetSystem("logger")).(GoalLogger).GetInstance()
+	logger.Info("Tearing down rooms system")
+	rooms.SetStatus(DownStatus, "")
+
+	return nil
+}
+
+func (rooms *rooms) GetDependencies() []string {
+	return []string{"logger"}
+}
+
+// Join adds a session to a room; sessions leave their rooms once closed
+func (rooms *rooms) Join(room string, session GoalSession) {
+	rooms.lock.Lock()
+
+	id := session.GetID()
+	if rooms.rooms[room] == nil {
+		rooms.rooms[room] = map[string]GoalSession{}
+	}
+
+	rooms.rooms[room][id] = session
+
+	if rooms.memberships[id] == nil {
+		rooms.memberships[id] = map[string]bool{}
+	}
+
+	rooms.memberships[id][room] = true
+
+	// The hook is kept when the session leaves all its rooms, so it is
+	// only registered once for the life of the session
+	hooked := rooms.closeHooks[id]
+	rooms.closeHooks[id] = true
+	rooms.lock.Unlock()
+
+	if !hooked {
+		session.OnClose(func() {
+			rooms.LeaveAll(session)
+
+			rooms.lock.Lock()
+			delete(rooms.closeHooks, id)
+			rooms.lock.Unlock()
+		})
+	}
+}
+
+func (rooms *rooms) Leave(room string, session GoalSession) {
+	rooms.lock.Lock()
+	defer rooms.lock.Unlock()
+
+	rooms.leave(room, session.GetID())
+}
+
+func (rooms *rooms) LeaveAll(session GoalSession) {
+	rooms.lock.Lock()
+	defer rooms.lock.Unlock()
+
+	id := session.GetID()
+	for room := range rooms.memberships[id] {
+		rooms.leave(room, id)
+	}
+
+	delete(rooms.memberships, id)
+}
+
+func (rooms *rooms) leave(room string, id string) {
+	delete(rooms.rooms[room], id)
+	if len(rooms.rooms[room]) == 0 {
+		delete(rooms.rooms, room)
+	}
+
+	delete(rooms.memberships[id], room)
+}
+
+func (rooms *rooms) GetMembers(room string) []GoalSession {
+	rooms.lock.RLock()
+	defer rooms.lock.RUnlock()
+
+	members := []GoalSession{}
+	for _, session := range rooms.rooms[room] {
+		members = append(members, session)
+	}
+
+	return members
+}
+
+func (rooms *rooms) GetRooms(session GoalSession) []string {
+	rooms.lock.RLock()
+	defer rooms.lock.RUnlock()
+
+	names := []string{}
+	for room := range rooms.memberships[session.GetID()] {
+		names = append(names, room)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Broadcast emits messages to every member of a room, each one
+// receiving them in the encoding its session negotiated; broadcasts are
+// pushes, and never reply to the envelope being processed by the sender
+func (rooms *rooms) Broadcast(ctx context.Context, room string, messages ...proto.Message) error {
+	broadcastError := GoalBroadcastError{}
+	ctx = WithMessageID(ctx, 0)
+
+	for _, session := range rooms.GetMembers(room) {
+		err := session.Emit(ctx, messages...)
+		if err != nil {
+			broadcastError[session.GetID()] = err
+		}
+	}
+
+	if len(broadcastError) > 0 {
+		return broadcastError
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package systems_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	. "github.com/Wizcorp/goal/src/proto"
+	. "github.com/Wizcorp/goal/src/systems"
+)
+
+func TestBroadcastToRoomMembers(t *testing.T) {
+	server := NewTestServer()
+	server.RegisterSystem(1, "services", NewEmptyServices())
+	server.RegisterSystem(2, "rooms", NewRooms())
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	defer server.Stop()
+
+	services := (*server.GetSystem("services")).(GoalServices)
+	rooms := (*server.GetSystem("rooms")).(GoalRooms)
+
+	member := &recordingConnection{}
+	memberSession := NewSession(member, "member", &ProtobufCodec{}, services.EmitMessages)
+	leaver := &recordingConnection{}
+	leaverSession := NewSession(leaver, "leaver", &ProtobufCodec{}, services.EmitMessages)
+	outsider := &recordingConnection{}
+	outsiderSession := NewSession(outsider, "outsider", &ProtobufCodec{}, services.EmitMessages)
+
+	rooms.Join("match", memberSession)
+	rooms.Join("match", leaverSession)
+	rooms.Join("lobby", outsiderSession)
+	leaverSession.Close()
+
+	// Broadcasts triggered by a message handler must not reply to its envelope
+	ctx := WithMessageID(WithSession(context.Background(), outsiderSession), 3)
+
+	err = rooms.Broadcast(ctx, "match", &GoalPingResponse{Timestamp: 1})
+	if err != nil {
+		t.Fatalf("Failed to broadcast: %v", err)
+	}
+
+	if len(member.Messages) != 1 || len(leaver.Messages) != 0 || len(outsider.Messages) != 0 {
+		t.Fatalf("Unexpected deliveries: %d, %d, %d", len(member.Messages), len(leaver.Messages), len(outsider.Messages))
+	}
+
+	var envelope GoalMessageEnvelope
+	proto.Unmarshal(member.Messages[0], &envelope)
+
+	if envelope.Id != 0 {
+		t.Errorf("Broadcast was sent as a reply: %d", envelope.Id)
+	}
+
+	if len(rooms.GetMembers("match")) != 1 || len(rooms.GetRooms(leaverSession)) != 0 {
+		t.Error("Closed session did not leave its rooms")
+	}
+}
+
+func TestBroadcastDoesNotReplyToSender(t *testing.T) {
+	server := NewTestServer()
+	server.RegisterSystem(1, "services", NewEmptyServices())
+	server.RegisterSystem(2, "rooms", NewRooms())
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	defer server.Stop()
+
+	services := (*server.GetSystem("services")).(GoalServices)
+	rooms := (*server.GetSystem("rooms")).(GoalRooms)
+
+	sender := &recordingConnection{}
+	senderSession := NewSession(sender, "sender", &ProtobufCodec{}, services.EmitMessages)
+	rooms.Join("match", senderSession)
+
+	ctx := WithMessageID(WithSession(context.Background(), senderSession), 5)
+
+	err = rooms.Broadcast(ctx, "match", &GoalPingResponse{Timestamp: 1})
+	if err != nil {
+		t.Fatalf("Failed to broadcast: %v", err)
+	}
+
+	if len(sender.Messages) != 1 {
+		t.Fatalf("Expected the sender to receive the broadcast, got %d messages", len(sender.Messages))
+	}
+
+	var envelope GoalMessageEnvelope
+	proto.Unmarshal(sender.Messages[0], &envelope)
+
+	if envelope.Id != 0 {
+		t.Errorf("Broadcast was sent to the sender as a reply to %d", envelope.Id)
+	}
+}
+
+type hookCountingSession struct {
+	GoalSession
+	hooks int
+}
+
+func (session *hookCountingSession) OnClose(callback func()) {
+	session.hooks++
+	session.GoalSession.OnClose(callback)
+}
+
+func TestRejoiningRegistersOneCloseHook(t *testing.T) {
+	rooms := NewRooms()
+	session := &hookCountingSession{
+		GoalSession: NewSession(&recordingConnection{}, "player", &ProtobufCodec{}, nil),
+	}
+
+	for i := 0; i < 3; i++ {
+		rooms.Join("match", session)
+		rooms.Join("lobby", session)
+		rooms.LeaveAll(session)
+	}
+
+	rooms.Join("match", session)
+
+	if session.hooks != 1 {
+		t.Errorf("Expected a single close hook, got %d", session.hooks)
+	}
+
+	session.Close()
+
+	if len(rooms.GetMembers("match")) != 0 {
+		t.Error("Closed session did not leave its rooms")
+	}
+}
@@ -0,0 +1,196 @@
+package systems
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Policies applied when a message is emitted to a full send queue
+const (
+	DropOldestOverflow = "dropOldest"
+	DropNewestOverflow = "dropNewest"
+	DisconnectOverflow = "disconnect"
+)
+
+var ErrSendQueueFull = errors.New("Send queue is full")
+var ErrSendQueueClosed = errors.New("Send queue is closed")
+
+type sendQueueMetrics struct {
+	Depth       prometheus.Gauge
+	Dropped     prometheus.Counter
+	Disconnects prometheus.Counter
+}
+
+type sendQueueFrame struct {
+	messageType int
+	data        []byte
+}
+
+// sendQueue serializes writes to a connection: messages are queued by
+// emitters, and written by a single goroutine
+type sendQueue struct {
+	conn     GoalMessageStreamConnection
+	frames   chan sendQueueFrame
+	overflow string
+	timeout  time.Duration
+	metrics  *sendQueueMetrics
+	closed   bool
+	lock     sync.Mutex
+}
+
+type writeDeadliner interface {
+	SetWriteDeadline(deadline time.Time) error
+}
+
+type sendQueueWriter struct {
+	bytes.Buffer
+	queue       *sendQueue
+	messageType int
+}
+
+func newSendQueueMetrics() *sendQueueMetrics {
+	return &sendQueueMetrics{
+		Depth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "goal",
+			Subsystem: "http",
+			Name:      "send_queue_depth",
+			Help:      "Number of messages waiting to be written to message stream connections",
+		}),
+		Dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "goal",
+			Subsystem: "http",
+			Name:      "send_queue_dropped_total",
+			Help:      "Number of messages dropped because a send queue was full",
+		}),
+		Disconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "goal",
+			Subsystem: "http",
+			Name:      "send_queue_disconnects_total",
+			Help:      "Number of connections closed because their send queue was full",
+		}),
+	}
+}
+
+func (metrics *sendQueueMetrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{metrics.Depth, metrics.Dropped, metrics.Disconnects}
+}
+
+// newSendQueue creates a queue writing to a connection; when the connection
+// supports it, writes taking longer than timeout fail
+func newSendQueue(conn GoalMessageStreamConnection, size int, overflow string, timeout time.Duration, metrics *sendQueueMetrics) *sendQueue {
+	queue := &sendQueue{
+		conn:     conn,
+		frames:   make(chan sendQueueFrame, size),
+		overflow: overflow,
+		timeout:  timeout,
+		metrics:  metrics,
+	}
+
+	go queue.run()
+
+	return queue
+}
+
+func (queue *sendQueue) NextWriter(messageType int) (io.WriteCloser, error) {
+	return &sendQueueWriter{
+		queue:       queue,
+		messageType: messageType,
+	}, nil
+}
+
+func (queue *sendQueue) WriteMessage(messageType int, data []byte) error {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	if queue.closed {
+		return ErrSendQueueClosed
+	}
+
+	frame := sendQueueFrame{messageType, data}
+
+	select {
+	case queue.frames <- frame:
+		queue.metrics.Depth.Inc()
+		return nil
+	default:
+	}
+
+	switch queue.overflow {
+	case DropOldestOverflow:
+		select {
+		case <-queue.frames:
+			queue.metrics.Depth.Dec()
+			queue.metrics.Dropped.Inc()
+		default:
+		}
+
+		select {
+		case queue.frames <- frame:
+			queue.metrics.Depth.Inc()
+			return nil
+		default:
+			queue.metrics.Dropped.Inc()
+			return ErrSendQueueFull
+		}
+	case DisconnectOverflow:
+		queue.metrics.Disconnects.Inc()
+		queue.closeLocked()
+		return ErrSendQueueFull
+	default:
+		queue.metrics.Dropped.Inc()
+		return ErrSendQueueFull
+	}
+}
+
+// Close stops accepting messages; messages already queued are written
+// before the connection is closed
+func (queue *sendQueue) Close() error {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	queue.closeLocked()
+
+	return nil
+}
+
+func (queue *sendQueue) closeLocked() {
+	if queue.closed {
+		return
+	}
+
+	queue.closed = true
+	close(queue.frames)
+}
+
+func (queue *sendQueue) run() {
+	failed := false
+
+	for frame := range queue.frames {
+		queue.metrics.Depth.Dec()
+
+		if failed {
+			continue
+		}
+
+		if conn, ok := queue.conn.(writeDeadliner); ok && queue.timeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(queue.timeout))
+		}
+
+		err := queue.conn.WriteMessage(frame.messageType, frame.data)
+		if err != nil {
+			failed = true
+			queue.conn.Close()
+		}
+	}
+
+	queue.conn.Close()
+}
+
+func (writer *sendQueueWriter) Close() error {
+	return writer.queue.WriteMessage(writer.messageType, writer.Bytes())
+}
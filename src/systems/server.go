@@ -1,9 +1,13 @@
 package systems
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-errors/errors"
 
@@ -13,14 +17,24 @@ import (
 type GoalServer interface {
 	RegisterSystem(runlevel int, name string, system GoalSystem)
 	GetSystem(name string) *GoalSystem
+	GetSystemStates() map[string]GoalSystemState
 	Start() error
 	Stop() error
+	Reconfigure(config *GoalConfig) error
 }
 
+// Default setup and teardown timeout of systems, in seconds
+const defaultSystemTimeout = 30
+
+type systemCall func(ctx context.Context, system GoalSystem, config *GoalConfig) error
+
 type server struct {
 	Config    *GoalConfig
 	Systems   map[string]GoalSystem
 	runlevels map[int]GoalRunlevel
+	states    map[string]GoalSystemState
+	late      map[string]<-chan error
+	lock      sync.RWMutex
 }
 
 func NewEmptyServer(config *GoalConfig) *server {
@@ -28,14 +42,18 @@ func NewEmptyServer(config *GoalConfig) *server {
 		Config:    config,
 		Systems:   map[string]GoalSystem{},
 		runlevels: map[int]GoalRunlevel{},
+		states:    map[string]GoalSystemState{},
+		late:      map[string]<-chan error{},
 	}
 }
 
+// NewServer creates a server with a new instance of every system
+// registered through RegisterSystem and RegisterSystemFactory
 func NewServer(config *GoalConfig) *server {
 	server := NewEmptyServer(config)
 
-	for _, r := range systems {
-		server.RegisterSystem(r.Runlevel, r.Name, r.System)
+	for _, r := range defaultSystems {
+		server.RegisterSystem(r.Runlevel, r.Name, r.Factory())
 	}
 
 	return server
@@ -77,21 +95,10 @@ func (server *server) OverrideSystem(name string, system GoalSystem) {
 	log.Panicf("Cannot override system %s since it was never registered", name)
 }
 
-func (server *server) GetRunlevels() []GoalRunlevel {
-	runlevels := []GoalRunlevel{}
-	keys := []int{}
-
-	for key := range server.runlevels {
-		keys = append(keys, key)
-	}
-
-	sort.Ints(keys)
-
-	for _, key := range keys {
-		runlevels = append(runlevels, server.runlevels[key])
-	}
-
-	return runlevels
+// GetRunlevels returns the registered systems grouped in the order
+// they will be set up, based on their dependencies
+func (server *server) GetRunlevels() ([]GoalRunlevel, error) {
+	return server.resolveRunlevels()
 }
 
 func (server *server) GetSystem(name string) *GoalSystem {
@@ -105,71 +112,391 @@ func (server *server) GetSystem(name string) *GoalSystem {
 	return &system
 }
 
+// GetSystemStates returns the current state of every registered system;
+// once a system is set up, the state it reports itself is used
+func (server *server) GetSystemStates() map[string]GoalSystemState {
+	states := map[string]GoalSystemState{}
+
+	for name, system := range server.Systems {
+		state := server.getState(name)
+
+		if state.Status == UpStatus {
+			if reporter, ok := system.(GoalSystemWithState); ok {
+				state = reporter.GetState()
+			} else {
+				state.Status = system.GetStatus()
+			}
+		}
+
+		states[name] = state
+	}
+
+	return states
+}
+
+func (server *server) getState(name string) GoalSystemState {
+	server.lock.RLock()
+	defer server.lock.RUnlock()
+
+	state, found := server.states[name]
+	if !found {
+		return GoalSystemState{Status: DownStatus}
+	}
+
+	return state
+}
+
+func (server *server) setState(name string, status Status, reason string) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.states[name] = GoalSystemState{
+		Status: status,
+		Reason: reason,
+		Since:  time.Now(),
+	}
+}
+
+func (server *server) getConfig() *GoalConfig {
+	server.lock.RLock()
+	defer server.lock.RUnlock()
+
+	return server.Config
+}
+
 func (server *server) Start() error {
-	for runlevel, systems := range server.GetRunlevels() {
+	runlevels, err := server.GetRunlevels()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	logger := (*server.GetSystem("logger")).(GoalLogger).GetInstance()
+
+	for runlevel, systems := range runlevels {
 		err := server.setupLevel(runlevel, systems)
 		if err != nil {
-			return errors.Wrap(err, 0)
+			logger.WithFields(LogFields{
+				"error": err,
+			}).Error("Failed to start Goal server, tearing down started systems")
+
+			return &GoalStartError{
+				Err:      err,
+				Rollback: server.rollback(runlevels[:runlevel+1]),
+			}
 		}
 	}
 
-	logger := (*server.GetSystem("logger")).(GoalLogger).GetInstance()
 	logger.Info("Goal server is up and running")
 
 	return nil
 }
 
 func (server *server) setupLevel(level int, systems GoalRunlevel) error {
-	for name, system := range systems {
-		subconfig, err := GetSubconfig(name, server.Config)
-		if err != nil {
-			return errors.Wrap(err, 0)
+	for name := range systems {
+		server.setState(name, StartingStatus, "")
+	}
+
+	failures, late := server.runLevel(systems, "setupTimeout", func(ctx context.Context, system GoalSystem, config *GoalConfig) error {
+		return system.Setup(ctx, server, config)
+	})
+
+	server.lock.Lock()
+	for name, result := range late {
+		server.late[name] = result
+	}
+	server.lock.Unlock()
+
+	for name := range systems {
+		if failures[name] != nil {
+			server.setState(name, FailedStatus, failures[name].Error())
+		} else {
+			server.setState(name, UpStatus, "")
 		}
+	}
+
+	if len(failures) > 0 {
+		return failures
+	}
+
+	return nil
+}
+
+// rollback tears down, in reverse order, all systems which were
+// successfully set up in the given runlevels; systems whose setup timed
+// out are waited for, and torn down if their setup eventually succeeds
+func (server *server) rollback(runlevels []GoalRunlevel) error {
+	failures := GoalSystemsError{}
 
-		err = system.Setup(server, subconfig)
+	for runlevel := len(runlevels) - 1; runlevel >= 0; runlevel-- {
+		for name := range runlevels[runlevel] {
+			err := server.awaitLateSetup(name)
+			if err != nil {
+				failures[name] = err
+			}
+		}
+
+		started := GoalRunlevel{}
+		for name, system := range runlevels[runlevel] {
+			if server.getState(name).Status == UpStatus {
+				started[name] = system
+			}
+		}
+
+		err := server.teardownLevel(runlevel, started)
 		if err != nil {
-			return errors.Wrap(err, 0)
+			for name, failure := range err.(GoalSystemsError) {
+				failures[name] = failure
+			}
 		}
 	}
 
+	if len(failures) > 0 {
+		return failures
+	}
+
 	return nil
 }
 
+// awaitLateSetup waits, up to the system's teardown timeout, for the setup
+// of a system which timed out to return, and marks it as up if it succeeded
+func (server *server) awaitLateSetup(name string) error {
+	server.lock.Lock()
+	result, found := server.late[name]
+	delete(server.late, name)
+	server.lock.Unlock()
+
+	if !found {
+		return nil
+	}
+
+	subconfig, err := GetSubconfig(name, server.getConfig())
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	timeout := time.Duration(subconfig.Int64("teardownTimeout", defaultSystemTimeout)) * time.Second
+
+	select {
+	case err := <-result:
+		if err == nil {
+			server.setState(name, UpStatus, "")
+		}
+
+		return nil
+	case <-time.After(timeout):
+		return errors.Errorf("setup still running after timing out, gave up after %v", timeout)
+	}
+}
+
 func (server *server) Stop() error {
 	logger := (*server.GetSystem("logger")).(GoalLogger).GetInstance()
 	logger.Info("Stopping Goal server")
 
-	runlevels := server.GetRunlevels()
+	runlevels, err := server.GetRunlevels()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	// Every level is torn down even if a higher one failed, as on rollback
+	failures := GoalSystemsError{}
 
 	for runlevel := len(runlevels) - 1; runlevel >= 0; runlevel-- {
 		systems := runlevels[runlevel]
 		err := server.teardownLevel(runlevel, systems)
 		if err != nil {
-			return errors.Wrap(err, 0)
+			for name, failure := range err.(GoalSystemsError) {
+				failures[name] = failure
+			}
 		}
 	}
 
+	if len(failures) > 0 {
+		return failures
+	}
+
 	logger.Info("Goal server stopped")
 
 	return nil
 }
 
+// Reconfigure notifies running systems whose subconfig has changed, and
+// then replaces the server's configuration
+func (server *server) Reconfigure(config *GoalConfig) error {
+	runlevels, err := server.GetRunlevels()
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	oldConfig := server.getConfig()
+	failures := GoalSystemsError{}
+
+	for _, systems := range runlevels {
+		for _, name := range sortedNames(systems) {
+			system, ok := systems[name].(GoalSystemWithReconfigure)
+			if !ok || server.getState(name).Status != UpStatus {
+				continue
+			}
+
+			if !SubconfigChanged(name, oldConfig, config) {
+				continue
+			}
+
+			err := server.reconfigureSystem(name, system, oldConfig, config)
+			if err != nil {
+				failures[name] = err
+			}
+		}
+	}
+
+	server.lock.Lock()
+	server.Config = config
+	server.lock.Unlock()
+
+	if len(failures) > 0 {
+		return failures
+	}
+
+	return nil
+}
+
+func (server *server) reconfigureSystem(name string, system GoalSystemWithReconfigure, oldConfig *GoalConfig, newConfig *GoalConfig) error {
+	oldSubconfig, err := GetSubconfig(name, oldConfig)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	newSubconfig, err := GetSubconfig(name, newConfig)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
+	return system.Reconfigure(server, oldSubconfig, newSubconfig)
+}
+
 func (server *server) teardownLevel(level int, systems GoalRunlevel) error {
+	started := GoalRunlevel{}
 	for name, system := range systems {
-		if system.GetStatus() != UpStatus {
-			continue
+		if server.getState(name).Status == UpStatus && system.GetStatus() != DownStatus {
+			started[name] = system
+			server.setState(name, StoppingStatus, "")
 		}
+	}
 
-		subconfig, err := GetSubconfig(name, server.Config)
-		if err != nil {
-			return errors.Wrap(err, 0)
+	failures, _ := server.runLevel(started, "teardownTimeout", func(ctx context.Context, system GoalSystem, config *GoalConfig) error {
+		return system.Teardown(ctx, server, config)
+	})
+
+	for name := range started {
+		if failures[name] != nil {
+			server.setState(name, FailedStatus, failures[name].Error())
+		} else {
+			server.setState(name, DownStatus, "")
 		}
+	}
+
+	if len(failures) > 0 {
+		return failures
+	}
+
+	return nil
+}
 
-		err = system.Teardown(server, subconfig)
+// runLevel calls the given function concurrently for all systems of a runlevel,
+// waits for all of them to complete or time out, and returns their errors along
+// with the results of calls which timed out and are still running
+func (server *server) runLevel(systems GoalRunlevel, timeoutKey string, call systemCall) (GoalSystemsError, map[string]<-chan error) {
+	failures := GoalSystemsError{}
+	late := map[string]<-chan error{}
+	subconfigs := map[string]*GoalConfig{}
+
+	for name := range systems {
+		subconfig, err := GetSubconfig(name, server.getConfig())
 		if err != nil {
-			return errors.Wrap(err, 0)
+			failures[name] = errors.Wrap(err, 0)
+			continue
 		}
+
+		subconfigs[name] = subconfig
 	}
 
-	return nil
+	lock := sync.Mutex{}
+	group := sync.WaitGroup{}
+
+	for name, config := range subconfigs {
+		group.Add(1)
+
+		go func(name string, system GoalSystem, config *GoalConfig) {
+			defer group.Done()
+
+			timeout := time.Duration(config.Int64(timeoutKey, defaultSystemTimeout)) * time.Second
+			result, err := callWithTimeout(system, config, timeout, call)
+			if err != nil {
+				lock.Lock()
+				failures[name] = err
+				if result != nil {
+					late[name] = result
+				}
+				lock.Unlock()
+			}
+		}(name, systems[name], config)
+	}
+
+	group.Wait()
+
+	return failures, late
+}
+
+// callWithTimeout calls a system with a context which is cancelled once the
+// timeout expires; systems are expected to give up and return when it is.
+// If the call times out, the channel its result will be sent to is returned
+func callWithTimeout(system GoalSystem, config *GoalConfig, timeout time.Duration, call systemCall) (<-chan error, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	result := make(chan error, 1)
+	go func() {
+		defer cancel()
+		result <- call(ctx, system, config)
+	}()
+
+	select {
+	case err := <-result:
+		return nil, err
+	case <-ctx.Done():
+		return result, errors.Errorf("timed out after %v", timeout)
+	}
+}
+
+// GoalSystemsError combines the errors returned by the systems
+// of a runlevel, indexed by system name
+type GoalSystemsError map[string]error
+
+func (systemsError GoalSystemsError) Error() string {
+	names := []string{}
+	for name := range systemsError {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	messages := []string{}
+	for _, name := range names {
+		messages = append(messages, fmt.Sprintf("%s: %v", name, systemsError[name]))
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// GoalStartError is returned when the server fails to start, and
+// holds the errors which occurred while tearing down started systems
+type GoalStartError struct {
+	Err      error
+	Rollback error
+}
+
+func (startError *GoalStartError) Error() string {
+	if startError.Rollback == nil {
+		return startError.Err.Error()
+	}
+
+	return fmt.Sprintf("%v (rollback failed: %v)", startError.Err, startError.Rollback)
 }
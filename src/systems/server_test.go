@@ -0,0 +1,317 @@
+package systems_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/Wizcorp/goal/src/api"
+	. "github.com/Wizcorp/goal/src/systems"
+)
+
+type eventLog struct {
+	lock    sync.Mutex
+	entries []string
+}
+
+func (events *eventLog) Add(entry string) {
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	events.entries = append(events.entries, entry)
+}
+
+func (events *eventLog) String() string {
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	return strings.Join(events.entries, ",")
+}
+
+type recordingSystem struct {
+	Name         string
+	Dependencies []string
+	Events       *eventLog
+	Status       Status
+}
+
+func (system *recordingSystem) Setup(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	system.Events.Add("setup:" + system.Name)
+	system.Status = UpStatus
+
+	return nil
+}
+
+func (system *recordingSystem) Teardown(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	system.Events.Add("teardown:" + system.Name)
+	system.Status = DownStatus
+
+	return nil
+}
+
+func (system *recordingSystem) GetStatus() Status {
+	return system.Status
+}
+
+type recordingSystemWithDependencies struct {
+	recordingSystem
+}
+
+func (system *recordingSystemWithDependencies) GetDependencies() []string {
+	return system.Dependencies
+}
+
+type failingSystem struct {
+	recordingSystem
+	Block bool
+}
+
+func (system *failingSystem) Setup(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	if system.Block {
+		<-ctx.Done()
+	}
+
+	return errors.New("setup failed")
+}
+
+func (system *failingSystem) GetDependencies() []string {
+	if system.Dependencies == nil {
+		return []string{"logger"}
+	}
+
+	return system.Dependencies
+}
+
+type failingTeardownSystem struct {
+	recordingSystemWithDependencies
+}
+
+func (system *failingTeardownSystem) Teardown(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	system.recordingSystemWithDependencies.Teardown(ctx, server, config)
+
+	return errors.New("teardown failed")
+}
+
+type lateSystem struct {
+	recordingSystemWithDependencies
+}
+
+func (system *lateSystem) Setup(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	<-ctx.Done()
+	time.Sleep(200 * time.Millisecond)
+
+	return system.recordingSystemWithDependencies.Setup(ctx, server, config)
+}
+
+func newRecordingSystem(name string, events *eventLog, dependencies ...string) GoalSystem {
+	system := recordingSystem{
+		Name:         name,
+		Dependencies: dependencies,
+		Events:       events,
+		Status:       DownStatus,
+	}
+
+	if dependencies == nil {
+		return &system
+	}
+
+	return &recordingSystemWithDependencies{system}
+}
+
+func TestStartOrdersSystemsByDependencies(t *testing.T) {
+	events := &eventLog{}
+	server := NewTestServer()
+	server.RegisterSystem(1, "first", newRecordingSystem("first", events, "logger", "second"))
+	server.RegisterSystem(5, "second", newRecordingSystem("second", events, "logger"))
+	server.RegisterSystem(2, "legacy", newRecordingSystem("legacy", events))
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	err = server.Stop()
+	if err != nil {
+		t.Fatalf("Failed to stop server: %v", err)
+	}
+
+	expected := "setup:second,setup:first,setup:legacy,teardown:legacy,teardown:first,teardown:second"
+	if events.String() != expected {
+		t.Errorf("Unexpected order: %v", events)
+	}
+}
+
+func TestStartReportsMissingDependencies(t *testing.T) {
+	events := &eventLog{}
+	server := NewTestServer()
+	server.RegisterSystem(1, "first", newRecordingSystem("first", events, "missing"))
+
+	err := server.Start()
+	if err == nil || !strings.Contains(err.Error(), "first -> missing") {
+		t.Errorf("Missing dependency was not reported: %v", err)
+	}
+
+	if events.String() != "" {
+		t.Errorf("Systems were set up despite missing dependencies: %v", events)
+	}
+}
+
+func TestStartReportsDependencyCycles(t *testing.T) {
+	events := &eventLog{}
+	server := NewTestServer()
+	server.RegisterSystem(1, "first", newRecordingSystem("first", events, "second"))
+	server.RegisterSystem(1, "second", newRecordingSystem("second", events, "first"))
+
+	err := server.Start()
+	if err == nil || !strings.Contains(err.Error(), "first -> second -> first") {
+		t.Errorf("Dependency cycle was not reported: %v", err)
+	}
+
+	if events.String() != "" {
+		t.Errorf("Systems were set up despite a dependency cycle: %v", events)
+	}
+}
+
+func TestStartCombinesErrorsAndTimeouts(t *testing.T) {
+	events := &eventLog{}
+	server := NewTestServer()
+	server.Config.Set("goal.blocking.setupTimeout", 1)
+	server.RegisterSystem(1, "failing", &failingSystem{})
+	server.RegisterSystem(1, "blocking", &failingSystem{Block: true})
+	server.RegisterSystem(1, "working", newRecordingSystem("working", events, "logger"))
+
+	err := server.Start()
+	if err == nil {
+		t.Fatal("Server started despite failing systems")
+	}
+
+	message := err.Error()
+	if !strings.Contains(message, "blocking: timed out after 1s") || !strings.Contains(message, "failing: setup failed") {
+		t.Errorf("Errors were not combined: %s", message)
+	}
+
+	if events.String() != "setup:working,teardown:working" {
+		t.Errorf("Systems of the same runlevel were not set up: %s", events)
+	}
+}
+
+func TestStartTearsDownSystemsSetUpAfterTimeout(t *testing.T) {
+	events := &eventLog{}
+	server := NewTestServer()
+	server.Config.Set("goal.late.setupTimeout", 1)
+	system := newRecordingSystem("late", events, "logger").(*recordingSystemWithDependencies)
+	server.RegisterSystem(1, "late", &lateSystem{*system})
+
+	err := server.Start()
+	if err == nil || !strings.Contains(err.Error(), "late: timed out after 1s") {
+		t.Fatalf("Timeout was not reported: %v", err)
+	}
+
+	if events.String() != "setup:late,teardown:late" {
+		t.Errorf("System set up after timing out was not torn down: %s", events)
+	}
+
+	if server.GetSystemStates()["late"].Status != DownStatus {
+		t.Errorf("Unexpected state: %v", server.GetSystemStates()["late"])
+	}
+}
+
+func TestStartRollsBackStartedSystems(t *testing.T) {
+	events := &eventLog{}
+	server := NewTestServer()
+	server.RegisterSystem(1, "first", newRecordingSystem("first", events, "logger"))
+	server.RegisterSystem(2, "second", newRecordingSystem("second", events, "first"))
+	server.RegisterSystem(3, "failing", &failingSystem{
+		recordingSystem: recordingSystem{Dependencies: []string{"second"}},
+	})
+	server.RegisterSystem(4, "never", newRecordingSystem("never", events, "failing"))
+
+	err := server.Start()
+	if err == nil || !strings.Contains(err.Error(), "failing: setup failed") {
+		t.Fatalf("Setup error was not reported: %v", err)
+	}
+
+	if _, ok := err.(*GoalStartError); !ok {
+		t.Errorf("Unexpected error type: %T", err)
+	}
+
+	expected := "setup:first,setup:second,teardown:second,teardown:first"
+	if events.String() != expected {
+		t.Errorf("Started systems were not torn down in reverse order: %s", events)
+	}
+}
+
+func TestStopTearsDownAllLevels(t *testing.T) {
+	events := &eventLog{}
+	server := NewTestServer()
+	system := newRecordingSystem("breaking", events, "first").(*recordingSystemWithDependencies)
+	server.RegisterSystem(1, "first", newRecordingSystem("first", events, "logger"))
+	server.RegisterSystem(2, "breaking", &failingTeardownSystem{*system})
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	err = server.Stop()
+	if err == nil || !strings.Contains(err.Error(), "breaking: teardown failed") {
+		t.Errorf("Teardown error was not reported: %v", err)
+	}
+
+	if _, ok := err.(GoalSystemsError); !ok {
+		t.Errorf("Unexpected error type: %T", err)
+	}
+
+	expected := "setup:first,setup:breaking,teardown:breaking,teardown:first"
+	if events.String() != expected {
+		t.Errorf("Lower levels were not torn down: %s", events)
+	}
+}
+
+type reconfigurableSystem struct {
+	recordingSystemWithDependencies
+}
+
+func (system *reconfigurableSystem) Reconfigure(server GoalServer, oldConfig *GoalConfig, newConfig *GoalConfig) error {
+	system.Events.Add("reconfigure:" + system.Name + ":" + newConfig.String("value"))
+
+	return nil
+}
+
+func TestReconfigureChangedSystems(t *testing.T) {
+	events := &eventLog{}
+	server := NewTestServer()
+	server.Config.Set("goal.changed.value", "old")
+	server.Config.Set("goal.unchanged.value", "same")
+
+	for _, name := range []string{"changed", "unchanged"} {
+		system := newRecordingSystem(name, events, "logger").(*recordingSystemWithDependencies)
+		server.RegisterSystem(1, name, &reconfigurableSystem{*system})
+	}
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	config := NewEmptyConfig("test")
+	config.Set("goal.logger.level", "panic")
+	config.Set("goal.changed.value", "new")
+	config.Set("goal.unchanged.value", "same")
+
+	err = server.Reconfigure(config)
+	if err != nil {
+		t.Fatalf("Failed to reconfigure server: %v", err)
+	}
+
+	if !strings.HasSuffix(events.String(), ",reconfigure:changed:new") {
+		t.Errorf("Changed system was not reconfigured: %s", events)
+	}
+
+	if strings.Contains(events.String(), "reconfigure:unchanged") {
+		t.Errorf("Unchanged system was reconfigured: %s", events)
+	}
+}
@@ -3,14 +3,14 @@ package systems
 import (
 	"context"
 	"net/http"
-	"reflect"
 	"strings"
+	"sync"
 
 	"github.com/go-errors/errors"
-	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/any"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/twitchtv/twirp"
 
 	. "github.com/Wizcorp/goal/src/api"
@@ -18,50 +18,56 @@ import (
 )
 
 func init() {
-	RegisterSystem(4, "services", NewControllers())
+	RegisterSystemFactory(4, "services", func() GoalSystem {
+		return NewServices()
+	})
+	RegisterConfigSchema("services", servicesConfig{})
 }
 
-var serviceServers = make(map[string]GoalServiceServer)
-var servicesRegistry = make(map[string]GoalService)
-var handlers = make(map[string]GoalServiceHandler)
+// GoalServiceFactory creates the server, controller and hooks of a
+// service for every services system
+type GoalServiceFactory func() (GoalServiceServer, GoalService, *GoalHooks)
 
-// RegisterService is called to register the triplet of service, controller and hooks. Hooks and services
-func RegisterService(path string, server GoalServiceServer, service GoalService, hooks *GoalHooks) {
-	serviceServers[path] = server
-	servicesRegistry[path] = service
-
-	serviceType := reflect.TypeOf(service)
-	for i := 0; i < serviceType.NumMethod(); i++ {
-		methodType := serviceType.Method(i)
-
-		if strings.HasPrefix(methodType.Name, "Handle") {
-			method := reflect.ValueOf(service).Method(i)
-			registerMesssageHandler(methodType.Type, method)
-		}
-	}
+type serviceRecord struct {
+	Path    string
+	Factory GoalServiceFactory
 }
 
-func registerMesssageHandler(methodType reflect.Type, method reflect.Value) {
-	messageType := methodType.In(2)
-	messageInstance := reflect.New(messageType).Elem()
+var defaultServices = []serviceRecord{}
 
-	// Todo: add sanity check
-	fullname := proto.MessageName(messageInstance.Interface().(proto.Message))
-
-	if handlers[fullname] == nil {
-		handlers[fullname] = []reflect.Value{}
-	}
+// RegisterService is called to register the triplet of service, controller and hooks
+// on every services system created with NewServices. The same instances are shared
+// by all of those systems; use RegisterServiceFactory for services which hold state.
+func RegisterService(path string, server GoalServiceServer, service GoalService, hooks *GoalHooks) {
+	RegisterServiceFactory(path, func() (GoalServiceServer, GoalService, *GoalHooks) {
+		return server, service, hooks
+	})
+}
 
-	handlers[fullname] = append(handlers[fullname], method)
+// RegisterServiceFactory adds a service to every services system created
+// with NewServices; each system gets its own instances
+func RegisterServiceFactory(path string, factory GoalServiceFactory) {
+	defaultServices = append(defaultServices, serviceRecord{
+		Path:    path,
+		Factory: factory,
+	})
 }
 
 type GoalServices interface {
 	GoalSystem
-	ProcessJSONMessages(ctx context.Context, data []byte)
-	EmitJSONMessages(ctx context.Context, messages ...proto.Message) error
-	ProcessProtobufMessages(ctx context.Context, data []byte)
-	EmitProtobufMessages(ctx context.Context, messages ...proto.Message) error
+	ProcessData(ctx context.Context, data []byte)
+	EmitMessages(ctx context.Context, messages ...proto.Message) error
 	ProcessMessages(ctx context.Context, envelope *GoalMessageEnvelope)
+	Connect(ctx context.Context, session GoalSession) error
+	Disconnect(ctx context.Context, session GoalSession)
+	FindSession(id string) (GoalSession, bool)
+	FindSessions(key string, value interface{}) []GoalSession
+	GetSessions() []GoalSession
+	EmitTo(ctx context.Context, id string, messages ...proto.Message) error
+	RegisterService(path string, server GoalServiceServer, service GoalService, hooks *GoalHooks)
+	AddMessageHandler(name string, handler GoalMessageHandlerFunc)
+	Use(middleware ...GoalMessageMiddleware)
+	SetDispatchKey(key GoalDispatchKeyFunc)
 	GetServiceServers() *map[string]GoalServiceServer
 	GetServices() *map[string]GoalService
 	GetHandlers() *map[string]GoalServiceHandler
@@ -75,22 +81,53 @@ type GoalServiceServer interface {
 	ServeHTTP(http.ResponseWriter, *http.Request)
 }
 
-type GoalServiceHandler []reflect.Value
-
 type GoalServiceWithSetup interface {
-	Setup(server GoalServer, config *GoalConfig) error
+	Setup(ctx context.Context, server GoalServer, config *GoalConfig) error
 }
 
 type GoalServiceWithTeardown interface {
-	Teardown(server GoalServer, config *GoalConfig) error
+	Teardown(ctx context.Context, server GoalServer, config *GoalConfig) error
+}
+
+type GoalServiceWithReconfigure interface {
+	Reconfigure(server GoalServer, oldConfig *GoalConfig, newConfig *GoalConfig) error
+}
+
+type servicesConfig struct {
+	GoalSystemConfig
+	Dispatch servicesDispatchConfig `config:"dispatch"`
+	// Subconfigs of services, indexed by service name; see getServiceConfigKey
+	Controllers map[string]interface{} `config:"controllers"`
+}
+
+// getServiceConfigKey returns the key of the subconfig passed to a service,
+// relative to the services subconfig; the service at /twirp/goal.Ping/
+// reads its settings from goal.services.controllers.ping
+func getServiceConfigKey(path string) string {
+	name := strings.Trim(path, "/")
+	name = name[strings.LastIndexAny(name, "/.")+1:]
+
+	if name != "" {
+		name = strings.ToLower(name[:1]) + name[1:]
+	}
+
+	return "controllers." + name
 }
 
 type services struct {
-	Status   Status
-	Servers  *map[string]GoalServiceServer
-	Services *map[string]GoalService
-	Handlers *map[string]GoalServiceHandler
-	Logger   GoalLogger
+	GoalStatusTracker
+	Servers           map[string]GoalServiceServer
+	Services          map[string]GoalService
+	Handlers          map[string]GoalServiceHandler
+	Middleware        []GoalMessageMiddleware
+	Logger            GoalLogger
+	sessions          map[string]GoalSession
+	lock              sync.RWMutex
+	dispatch          GoalMessageHandlerFunc
+	panics            prometheus.Counter
+	dispatcher        dispatcher
+	dispatchKey       GoalDispatchKeyFunc
+	serviceMiddleware []GoalMessageMiddleware
 }
 
 // Hooks can be used to execute logic at certain key point of a
@@ -101,158 +138,379 @@ type GoalHooks = twirp.ServerHooks
 
 type GoalServiceEmitter func(ctx context.Context, messages ...proto.Message) error
 
-func NewControllers() *services {
-	return &services{
-		Status:   DownStatus,
-		Handlers: &handlers,
-		Servers:  &serviceServers,
-		Services: &servicesRegistry,
+type messageIDKey struct{}
+
+// GetMessageID returns the ID of the envelope being processed, or 0 when
+// the context does not come from a received envelope. Messages emitted
+// with this context are sent back in an envelope carrying the same ID.
+func GetMessageID(ctx context.Context) int32 {
+	id, ok := ctx.Value(messageIDKey{}).(int32)
+	if !ok {
+		return 0
+	}
+
+	return id
+}
+
+// WithMessageID returns a copy of the context in which emitted messages
+// reply to the envelope with the given ID; 0 is used for server pushes
+func WithMessageID(ctx context.Context, id int32) context.Context {
+	return context.WithValue(ctx, messageIDKey{}, id)
+}
+
+// NewServices creates a services system holding every service
+// registered through RegisterService and RegisterServiceFactory
+func NewServices() *services {
+	services := NewEmptyServices()
+	services.Use(defaultMiddleware...)
+
+	for _, r := range defaultServices {
+		server, service, hooks := r.Factory()
+		services.RegisterService(r.Path, server, service, hooks)
+	}
+
+	return services
+}
+
+// NewEmptyServices creates a services system without any services
+func NewEmptyServices() *services {
+	services := &services{
+		Servers:     map[string]GoalServiceServer{},
+		Services:    map[string]GoalService{},
+		Handlers:    map[string]GoalServiceHandler{},
+		sessions:    map[string]GoalSession{},
+		panics:      newPanicCounter("services"),
+		dispatcher:  inlineDispatcher{},
+		dispatchKey: sessionDispatchKey,
+	}
+
+	services.dispatch = services.processMessage
+
+	return services
+}
+
+// RegisterService adds a service to this services system only; it
+// must be called before the server is started
+func (services *services) RegisterService(path string, server GoalServiceServer, service GoalService, hooks *GoalHooks) {
+	services.Servers[path] = server
+	services.Services[path] = service
+
+	if service, ok := service.(GoalServiceWithMessageMiddleware); ok {
+		services.serviceMiddleware = service.GetMessageMiddleware()
+		defer func() {
+			services.serviceMiddleware = nil
+		}()
+	}
+
+	if registrar, ok := service.(GoalServiceWithMessageHandlers); ok {
+		registrar.RegisterMessageHandlers(services)
+	} else {
+		services.registerMethodHandlers(service)
 	}
 }
 
-func (services *services) Setup(server GoalServer, config *GoalConfig) error {
+// AddMessageHandler adds a handler for messages with the given full name;
+// services should prefer the typed OnMessage function. Handlers added while
+// a service is registered are wrapped by the middleware of that service
+func (services *services) AddMessageHandler(name string, handler GoalMessageHandlerFunc) {
+	handler = chainMiddleware(handler, services.serviceMiddleware)
+	services.Handlers[name] = append(services.Handlers[name], handler)
+}
+
+func (services *services) Setup(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	settings := &servicesConfig{}
+	err := DecodeConfig(config, settings)
+	if err != nil {
+		return errors.Wrap(err, 0)
+	}
+
 	services.Logger = (*server.GetSystem("logger")).(GoalLogger)
-	for name, controller := range *services.Services {
+	services.dispatcher = newDispatcher(&settings.Dispatch, func(ctx context.Context) string {
+		return services.dispatchKey(ctx)
+	})
+
+	for path, controller := range services.Services {
 		if controller, ok := interface{}(controller).(GoalServiceWithSetup); ok {
-			subconfig, err := GetSubconfig(name, config)
+			subconfig, err := GetNestedSubconfig(getServiceConfigKey(path), config)
 			if err != nil {
 				return errors.Wrap(err, 0)
 			}
 
-			err = controller.Setup(server, subconfig)
+			err = controller.Setup(ctx, server, subconfig)
 			if err != nil {
 				return errors.Wrap(err, 0)
 			}
 		}
 	}
 
-	services.Status = UpStatus
+	services.SetStatus(UpStatus, "")
 
 	return nil
 }
 
-func (services *services) Teardown(server GoalServer, config *GoalConfig) error {
-	for name, controller := range *services.Services {
+func (services *services) Teardown(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	for path, controller := range services.Services {
 		if controller, ok := interface{}(controller).(GoalServiceWithTeardown); ok {
-			subconfig, err := GetSubconfig(name, config)
+			subconfig, err := GetNestedSubconfig(getServiceConfigKey(path), config)
 			if err != nil {
 				return errors.Wrap(err, 0)
 			}
 
-			err = controller.Teardown(server, subconfig)
+			err = controller.Teardown(ctx, server, subconfig)
 			if err != nil {
 				return errors.Wrap(err, 0)
 			}
 		}
 	}
 
-	services.Status = DownStatus
+	services.dispatcher.Close()
+	services.SetStatus(DownStatus, "")
 
 	return nil
 }
 
-func (services *services) GetStatus() Status {
-	return services.Status
-}
+func (services *services) Reconfigure(server GoalServer, oldConfig *GoalConfig, newConfig *GoalConfig) error {
+	for path, controller := range services.Services {
+		if controller, ok := interface{}(controller).(GoalServiceWithReconfigure); ok {
+			key := getServiceConfigKey(path)
+			if !NestedSubconfigChanged(key, oldConfig, newConfig) {
+				continue
+			}
 
-func (services *services) GetServiceServers() *map[string]GoalServiceServer {
-	return services.Servers
+			oldSubconfig, err := GetNestedSubconfig(key, oldConfig)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+
+			newSubconfig, err := GetNestedSubconfig(key, newConfig)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+
+			err = controller.Reconfigure(server, oldSubconfig, newSubconfig)
+			if err != nil {
+				return errors.Wrap(err, 0)
+			}
+		}
+	}
+
+	return nil
 }
 
-func (services *services) GetServices() *map[string]GoalService {
-	return services.Services
+// Connect calls the OnConnect hook of services when a session is opened;
+// if one of them fails, the services which were already connected are
+// disconnected in reverse order
+func (services *services) Connect(ctx context.Context, session GoalSession) error {
+	connected := []GoalService{}
+
+	for _, service := range services.Services {
+		if hook, ok := service.(GoalServiceWithConnect); ok {
+			err := hook.OnConnect(ctx, session)
+			if err != nil {
+				for i := len(connected) - 1; i >= 0; i-- {
+					if hook, ok := connected[i].(GoalServiceWithDisconnect); ok {
+						hook.OnDisconnect(ctx, session)
+					}
+				}
+
+				return err
+			}
+
+			connected = append(connected, service)
+		}
+	}
+
+	services.lock.Lock()
+	services.sessions[session.GetID()] = session
+	services.lock.Unlock()
+
+	return nil
 }
 
-func (services *services) GetHandlers() *map[string]GoalServiceHandler {
-	return services.Handlers
+// Disconnect calls the OnDisconnect hook of services when a session is closed
+func (services *services) Disconnect(ctx context.Context, session GoalSession) {
+	services.lock.Lock()
+	delete(services.sessions, session.GetID())
+	services.lock.Unlock()
+
+	services.dispatcher.Release(session)
+
+	for _, service := range services.Services {
+		if service, ok := service.(GoalServiceWithDisconnect); ok {
+			service.OnDisconnect(ctx, session)
+		}
+	}
 }
 
-func (services *services) ProcessJSONMessages(ctx context.Context, data []byte) {
-	logger := services.Logger.GetInstance()
+// FindSession returns the connected session with the given ID
+func (services *services) FindSession(id string) (GoalSession, bool) {
+	services.lock.RLock()
+	defer services.lock.RUnlock()
 
-	var envelope GoalMessageEnvelope
-	err := jsonpb.UnmarshalString(string(data), &envelope)
+	session, found := services.sessions[id]
 
-	if err != nil {
-		logger.WithFields(LogFields{
-			"data": string(data),
-		}).Warn("JSON envelope could not be deserialized")
-		return
+	return session, found
+}
+
+// FindSessions returns the connected sessions whose attribute key is set to value
+func (services *services) FindSessions(key string, value interface{}) []GoalSession {
+	found := []GoalSession{}
+	for _, session := range services.GetSessions() {
+		attribute, ok := session.Get(key)
+		if ok && attribute == value {
+			found = append(found, session)
+		}
 	}
 
-	services.ProcessMessages(ctx, &envelope)
+	return found
 }
 
-func (services *services) EmitJSONMessages(ctx context.Context, messages ...proto.Message) error {
-	envelope, err := packEnvelope(messages)
-	if err != nil {
-		return err
+func (services *services) GetSessions() []GoalSession {
+	services.lock.RLock()
+	defer services.lock.RUnlock()
+
+	sessions := []GoalSession{}
+	for _, session := range services.sessions {
+		sessions = append(sessions, session)
 	}
 
-	marshaler := jsonpb.Marshaler{}
-	conn := ctx.Value("conn").(GoalMessageStreamConnection)
-	writer, err := conn.NextWriter(1)
-	if err != nil {
-		return err
+	return sessions
+}
+
+// EmitTo pushes messages to the connected session with the given ID;
+// ErrSessionNotFound is returned if the session is gone
+func (services *services) EmitTo(ctx context.Context, id string, messages ...proto.Message) error {
+	session, found := services.FindSession(id)
+	if !found {
+		return ErrSessionNotFound
 	}
 
-	return marshaler.Marshal(writer, envelope)
+	return session.Emit(ctx, messages...)
+}
+
+func (services *services) GetCollectors() []prometheus.Collector {
+	return []prometheus.Collector{services.panics}
 }
 
-func (services *services) ProcessProtobufMessages(ctx context.Context, data []byte) {
+func (services *services) GetDependencies() []string {
+	return []string{"logger"}
+}
+
+func (services *services) GetServiceServers() *map[string]GoalServiceServer {
+	return &services.Servers
+}
+
+func (services *services) GetServices() *map[string]GoalService {
+	return &services.Services
+}
+
+func (services *services) GetHandlers() *map[string]GoalServiceHandler {
+	return &services.Handlers
+}
+
+// ProcessData decodes an envelope received from the session found in the
+// context with the codec of the session, and processes its messages
+func (services *services) ProcessData(ctx context.Context, data []byte) {
 	logger := services.Logger.GetInstance()
 
+	session := GetSession(ctx)
+	if session == nil {
+		logger.Error("Cannot process data received outside of a session")
+		return
+	}
+
 	var envelope GoalMessageEnvelope
-	err := proto.Unmarshal(data, &envelope)
+	err := session.GetCodec().Unmarshal(data, &envelope)
 
 	if err != nil {
 		logger.WithFields(LogFields{
-			"data": data,
-		}).Warn("Protobuf envelope could not be deserialized")
+			"contentType": session.GetContentType(),
+			"data":        data,
+		}).Warn("Envelope could not be deserialized")
+		services.emitError(ctx, NewMessageError(InvalidEnvelopeErrorCode))
 		return
 	}
 
-	services.ProcessMessages(ctx, &envelope)
+	services.dispatchEnvelope(ctx, &envelope)
 }
 
-func (services *services) EmitProtobufMessages(ctx context.Context, messages ...proto.Message) error {
-	envelope, err := packEnvelope(messages)
+// EmitMessages sends messages to the session found in the context, in an
+// envelope encoded with the codec of the session
+func (services *services) EmitMessages(ctx context.Context, messages ...proto.Message) error {
+	envelope, err := packEnvelope(GetMessageID(ctx), messages)
 	if err != nil {
 		return err
 	}
 
-	data, err := proto.Marshal(envelope)
+	session := GetSession(ctx)
+	if session == nil {
+		return errors.New("No session found in context")
+	}
+
+	codec := session.GetCodec()
+	data, err := codec.Marshal(envelope)
 	if err != nil {
 		return err
 	}
 
-	conn := ctx.Value("conn").(GoalMessageStreamConnection)
-	return conn.WriteMessage(1, data)
+	return session.GetConnection().WriteMessage(codec.GetMessageType(), data)
 }
 
-// ProcessMessages is used to process received GoalEnvelopes
+// ProcessMessages is used to process received GoalEnvelopes; the ID of
+// the envelope is made available to handlers through GetMessageID
 func (services *services) ProcessMessages(ctx context.Context, envelope *GoalMessageEnvelope) {
 	logger := services.Logger.GetInstance()
+	ctx = WithMessageID(ctx, envelope.Id)
 
 	for _, data := range envelope.Messages {
 		var message ptypes.DynamicAny
 		err := ptypes.UnmarshalAny(data, &message)
 
 		if err != nil {
-			// Todo: send error to client
 			logger.WithFields(LogFields{
-				"message": message,
+				"type":  data.TypeUrl,
+				"error": err,
 			}).Warn("Message could not be deserialized")
+			services.emitError(ctx, NewMessageError(InvalidMessageErrorCode, data))
 			continue
 		}
 
-		services.processMessage(ctx, message.Message)
+		err = services.callHandler(ctx, services.getDispatch(), message.Message)
+		if err != nil {
+			services.emitError(ctx, err)
+		}
 	}
 }
 
-func (services *services) processMessage(ctx context.Context, message proto.Message) {
+// SetDispatchKey sets the key used to order the processing of envelopes
+// when they are dispatched to a worker pool; it defaults to the session ID
+func (services *services) SetDispatchKey(key GoalDispatchKeyFunc) {
+	services.dispatchKey = key
+}
+
+// dispatchEnvelope hands the processing of an envelope to the dispatcher
+// selected by the configuration
+func (services *services) dispatchEnvelope(ctx context.Context, envelope *GoalMessageEnvelope) {
+	services.dispatcher.Dispatch(ctx, func() {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			services.panics.Inc()
+			logPanic(services.Logger, LogFields{}, recovered, "Recovered from panic while processing an envelope")
+		}()
+
+		services.ProcessMessages(ctx, envelope)
+	})
+}
+
+// processMessage calls every handler of the message, and returns the
+// first error returned by them
+func (services *services) processMessage(ctx context.Context, message proto.Message) error {
 	name := proto.MessageName(message)
-	hook, found := (*services.Handlers)[name]
+	hook, found := services.Handlers[name]
 
 	if !found {
 		logger := services.Logger.GetInstance()
@@ -260,18 +518,53 @@ func (services *services) processMessage(ctx context.Context, message proto.Mess
 			"type":    name,
 			"message": message,
 		}).Warnf("Not hooks are registered to process message, ignoring")
-		return
+
+		anyMessage, err := ptypes.MarshalAny(message)
+		if err != nil {
+			return NewMessageError(UnknownMessageErrorCode)
+		}
+
+		return NewMessageError(UnknownMessageErrorCode, anyMessage)
 	}
 
-	for _, h := range hook {
-		h.Call([]reflect.Value{
-			reflect.ValueOf(ctx),
-			reflect.ValueOf(message),
-		})
+	var handlerErr error
+	for _, handler := range hook {
+		err := services.callHandler(ctx, handler, message)
+		if handlerErr == nil {
+			handlerErr = err
+		}
 	}
+
+	return handlerErr
+}
+
+// callHandler calls a message handler; panics are recovered, logged and
+// reported to the client as internal errors
+func (services *services) callHandler(ctx context.Context, handler GoalMessageHandlerFunc, message proto.Message) (err error) {
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+
+		services.panics.Inc()
+
+		fields := LogFields{
+			"type": proto.MessageName(message),
+		}
+
+		if session := GetSession(ctx); session != nil {
+			fields["session"] = session.GetID()
+		}
+
+		logPanic(services.Logger, fields, recovered, "Recovered from panic in message handler")
+		err = NewMessageError(InternalErrorCode)
+	}()
+
+	return handler(ctx, message)
 }
 
-func packEnvelope(messages []proto.Message) (*GoalMessageEnvelope, error) {
+func packEnvelope(id int32, messages []proto.Message) (*GoalMessageEnvelope, error) {
 	anyMessages := []*any.Any{}
 
 	for _, message := range messages {
@@ -283,6 +576,7 @@ func packEnvelope(messages []proto.Message) (*GoalMessageEnvelope, error) {
 	}
 
 	envelope := &GoalMessageEnvelope{
+		Id:       id,
 		Messages: anyMessages,
 	}
 
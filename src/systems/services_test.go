@@ -2,12 +2,17 @@ package systems_test
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/any"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
+	. "github.com/Wizcorp/goal/src/api"
 	. "github.com/Wizcorp/goal/src/proto"
 	. "github.com/Wizcorp/goal/src/systems"
 )
@@ -41,28 +46,15 @@ func (y *PingController) HandleGoalPingRequest(ctx context.Context, message *Goa
 }
 
 func setup(controller Ping, hooks *GoalHooks) (GoalServer, func()) {
-	path := PingPathPrefix
-	service := NewPingServer(controller, hooks)
-	RegisterService(path, service, controller, hooks)
+	controllers := NewEmptyServices()
+	controllers.RegisterService(PingPathPrefix, NewPingServer(controller, hooks), controller, hooks)
 
 	server := NewTestServer()
-	server.RegisterSystem(4, "controllers", NewControllers())
+	server.RegisterSystem(4, "controllers", controllers)
 	server.Start()
 
 	return server, func() {
-		controllers := (*server.GetSystem("controllers")).(GoalControllers)
-		services := controllers.GetServices()
-		handlers := controllers.GetHandlers()
-
 		server.Stop()
-
-		for key, _ := range *handlers {
-			delete(*handlers, key)
-		}
-
-		for key, _ := range *services {
-			delete(*services, key)
-		}
 	}
 }
 
@@ -70,7 +62,7 @@ func TestRegisterWithoutMessages(t *testing.T) {
 	server, teardown := setup(&PingControllerWithoutMessages{}, nil)
 	defer teardown()
 
-	controllers := (*server.GetSystem("controllers")).(GoalControllers)
+	controllers := (*server.GetSystem("controllers")).(GoalServices)
 
 	if len(*controllers.GetServices()) != 1 {
 		t.Errorf("Service was not registered")
@@ -85,7 +77,7 @@ func TestRegisterWithMessages(t *testing.T) {
 	server, teardown := setup(&PingController{}, nil)
 	defer teardown()
 
-	controllers := (*server.GetSystem("controllers")).(GoalControllers)
+	controllers := (*server.GetSystem("controllers")).(GoalServices)
 
 	if len(*controllers.GetServices()) != 1 {
 		t.Error("Service was not registered")
@@ -101,7 +93,7 @@ func TestProcessMessages(t *testing.T) {
 	server, teardown := setup(controller, nil)
 	defer teardown()
 
-	controllers := (*server.GetSystem("controllers")).(GoalControllers)
+	controllers := (*server.GetSystem("controllers")).(GoalServices)
 
 	message := &GoalPingRequest{
 		Timestamp: 123,
@@ -114,10 +106,400 @@ func TestProcessMessages(t *testing.T) {
 	}
 	bytes, _ := proto.Marshal(envelope)
 
-	ctx := context.Background()
-	controllers.ProcessMessages(ctx, bytes)
+	session := NewSession(&recordingConnection{}, "127.0.0.1:1234", &ProtobufCodec{}, controllers.EmitMessages)
+	controllers.ProcessData(WithSession(context.Background(), session), bytes)
 
 	if controller.Time != message.Timestamp {
 		t.Errorf("Times do not match: %d != %d", controller.Time, message.Timestamp)
 	}
 }
+
+func TestServicesAreScopedToServers(t *testing.T) {
+	first, teardownFirst := setup(&PingController{}, nil)
+	defer teardownFirst()
+
+	second, teardownSecond := setup(&PingControllerWithoutMessages{}, nil)
+	defer teardownSecond()
+
+	firstControllers := (*first.GetSystem("controllers")).(GoalServices)
+	secondControllers := (*second.GetSystem("controllers")).(GoalServices)
+
+	if len(*firstControllers.GetHandlers()) != 1 {
+		t.Error("Handler was not registered on the first server")
+	}
+
+	if len(*secondControllers.GetHandlers()) != 0 {
+		t.Error("Handler of the first server leaked into the second one")
+	}
+}
+
+func TestServersGetTheirOwnSystems(t *testing.T) {
+	first := NewServer(NewEmptyConfig("first"))
+	second := NewServer(NewEmptyConfig("second"))
+
+	firstServices := (*first.GetSystem("services")).(GoalServices)
+	secondServices := (*second.GetSystem("services")).(GoalServices)
+
+	if firstServices == secondServices {
+		t.Fatal("Servers share the same services system")
+	}
+
+	controller := &PingController{}
+	firstServices.RegisterService("/scoped", NewPingServer(controller, nil), controller, nil)
+
+	if _, found := (*secondServices.GetServices())["/scoped"]; found {
+		t.Error("Service registered on the first server leaked into the second one")
+	}
+}
+
+type EchoController struct {
+	PingController
+}
+
+func (y *EchoController) HandleGoalPingRequest(ctx context.Context, message *GoalPingRequest) {
+	GetSession(ctx).Emit(ctx, &GoalPingResponse{
+		Timestamp: message.Timestamp,
+	})
+}
+
+type recordingConnection struct {
+	Messages [][]byte
+	Closed   bool
+}
+
+func (conn *recordingConnection) NextWriter(messageType int) (io.WriteCloser, error) {
+	return nil, io.ErrClosedPipe
+}
+
+func (conn *recordingConnection) WriteMessage(messageType int, data []byte) error {
+	conn.Messages = append(conn.Messages, data)
+
+	return nil
+}
+
+func (conn *recordingConnection) Close() error {
+	conn.Closed = true
+
+	return nil
+}
+
+func TestRepliesCarryEnvelopeID(t *testing.T) {
+	server, teardown := setup(&EchoController{}, nil)
+	defer teardown()
+
+	controllers := (*server.GetSystem("controllers")).(GoalServices)
+	conn := &recordingConnection{}
+
+	session := NewSession(conn, "127.0.0.1:1234", &ProtobufCodec{}, controllers.EmitMessages)
+	ctx := WithSession(context.Background(), session)
+
+	data, _ := ptypes.MarshalAny(&GoalPingRequest{Timestamp: 123})
+	controllers.ProcessMessages(ctx, &GoalMessageEnvelope{
+		Id:       42,
+		Messages: []*any.Any{data},
+	})
+
+	if len(conn.Messages) != 1 {
+		t.Fatalf("Expected one reply, got %d", len(conn.Messages))
+	}
+
+	var reply GoalMessageEnvelope
+	proto.Unmarshal(conn.Messages[0], &reply)
+
+	if reply.Id != 42 {
+		t.Errorf("Reply does not carry the envelope ID: %d", reply.Id)
+	}
+}
+
+type RejectingController struct {
+	PingController
+}
+
+func (y *RejectingController) HandleGoalPingRequest(ctx context.Context, message *GoalPingRequest) error {
+	return NewMessageError("rejected", message)
+}
+
+func TestErrorsAreSentAsGoalErrors(t *testing.T) {
+	server, teardown := setup(&RejectingController{}, nil)
+	defer teardown()
+
+	controllers := (*server.GetSystem("controllers")).(GoalServices)
+	conn := &recordingConnection{}
+
+	session := NewSession(conn, "127.0.0.1:1234", &ProtobufCodec{}, controllers.EmitMessages)
+	ctx := WithSession(context.Background(), session)
+
+	known, _ := ptypes.MarshalAny(&GoalPingRequest{Timestamp: 123})
+	unknown, _ := ptypes.MarshalAny(&GoalPingResponse{Timestamp: 123})
+	controllers.ProcessMessages(ctx, &GoalMessageEnvelope{
+		Id:       7,
+		Messages: []*any.Any{known, unknown},
+	})
+
+	if len(conn.Messages) != 2 {
+		t.Fatalf("Expected two error replies, got %d", len(conn.Messages))
+	}
+
+	for i, code := range []string{"rejected", UnknownMessageErrorCode} {
+		var reply GoalMessageEnvelope
+		proto.Unmarshal(conn.Messages[i], &reply)
+
+		var goalError GoalError
+		ptypes.UnmarshalAny(reply.Messages[0], &goalError)
+
+		if reply.Id != 7 || goalError.Id != 7 || goalError.Code != code || len(goalError.Details) != 1 {
+			t.Errorf("Unexpected error reply: %v", goalError)
+		}
+	}
+}
+
+type TypedPingController struct {
+	PingControllerWithoutMessages
+}
+
+func (y *TypedPingController) RegisterMessageHandlers(services GoalServices) {
+	OnMessage(services, y.HandlePing)
+}
+
+func (y *TypedPingController) HandlePing(ctx context.Context, message *GoalPingRequest) error {
+	y.Time = message.Timestamp
+
+	return nil
+}
+
+func TestOnMessageRegistersTypedHandlers(t *testing.T) {
+	controller := &TypedPingController{}
+	server, teardown := setup(controller, nil)
+	defer teardown()
+
+	controllers := (*server.GetSystem("controllers")).(GoalServices)
+
+	data, _ := ptypes.MarshalAny(&GoalPingRequest{Timestamp: 123})
+	controllers.ProcessMessages(context.Background(), &GoalMessageEnvelope{
+		Messages: []*any.Any{data},
+	})
+
+	if controller.Time != 123 {
+		t.Errorf("Typed handler was not called: %d", controller.Time)
+	}
+}
+
+type GuardedPingController struct {
+	TypedPingController
+}
+
+func (y *GuardedPingController) GetMessageMiddleware() []GoalMessageMiddleware {
+	return []GoalMessageMiddleware{
+		func(next GoalMessageHandlerFunc) GoalMessageHandlerFunc {
+			return func(ctx context.Context, message proto.Message) error {
+				if message.(*GoalPingRequest).Timestamp < 0 {
+					return NewMessageError("rejected")
+				}
+
+				return next(ctx, message)
+			}
+		},
+	}
+}
+
+func TestMiddlewareWrapsMessageDispatch(t *testing.T) {
+	controller := &GuardedPingController{}
+	server, teardown := setup(controller, nil)
+	defer teardown()
+
+	controllers := (*server.GetSystem("controllers")).(GoalServices)
+
+	calls := []string{}
+	record := func(name string) GoalMessageMiddleware {
+		return func(next GoalMessageHandlerFunc) GoalMessageHandlerFunc {
+			return func(ctx context.Context, message proto.Message) error {
+				calls = append(calls, name)
+				return next(ctx, message)
+			}
+		}
+	}
+
+	controllers.Use(record("first"), record("second"))
+
+	conn := &recordingConnection{}
+	session := NewSession(conn, "127.0.0.1:1234", &ProtobufCodec{}, controllers.EmitMessages)
+	ctx := WithSession(context.Background(), session)
+
+	accepted, _ := ptypes.MarshalAny(&GoalPingRequest{Timestamp: 123})
+	rejected, _ := ptypes.MarshalAny(&GoalPingRequest{Timestamp: -1})
+	controllers.ProcessMessages(ctx, &GoalMessageEnvelope{
+		Messages: []*any.Any{accepted, rejected},
+	})
+
+	if len(calls) != 4 || calls[0] != "first" || calls[1] != "second" {
+		t.Errorf("Global middleware was not called in order: %v", calls)
+	}
+
+	if controller.Time != 123 {
+		t.Errorf("Handler was not called through middleware: %d", controller.Time)
+	}
+
+	if len(conn.Messages) != 1 {
+		t.Fatalf("Expected the service middleware to reject a message, got %d replies", len(conn.Messages))
+	}
+}
+
+type PanickingController struct {
+	PingController
+}
+
+func (y *PanickingController) HandleGoalPingRequest(ctx context.Context, message *GoalPingRequest) {
+	if message.Timestamp < 0 {
+		panic("negative timestamp")
+	}
+
+	y.Time = message.Timestamp
+}
+
+func TestHandlerPanicsAreRecovered(t *testing.T) {
+	controller := &PanickingController{}
+	server, teardown := setup(controller, nil)
+	defer teardown()
+
+	controllers := (*server.GetSystem("controllers")).(GoalServices)
+	conn := &recordingConnection{}
+
+	session := NewSession(conn, "127.0.0.1:1234", &ProtobufCodec{}, controllers.EmitMessages)
+	ctx := WithSession(context.Background(), session)
+
+	panicking, _ := ptypes.MarshalAny(&GoalPingRequest{Timestamp: -1})
+	valid, _ := ptypes.MarshalAny(&GoalPingRequest{Timestamp: 123})
+	controllers.ProcessMessages(ctx, &GoalMessageEnvelope{
+		Id:       3,
+		Messages: []*any.Any{panicking, valid},
+	})
+
+	if controller.Time != 123 {
+		t.Errorf("Messages following a panic were not processed: %d", controller.Time)
+	}
+
+	if len(conn.Messages) != 1 {
+		t.Fatalf("Expected one error reply, got %d", len(conn.Messages))
+	}
+
+	var reply GoalMessageEnvelope
+	proto.Unmarshal(conn.Messages[0], &reply)
+
+	var goalError GoalError
+	ptypes.UnmarshalAny(reply.Messages[0], &goalError)
+
+	if goalError.Code != InternalErrorCode {
+		t.Errorf("Unexpected error reply: %v", goalError)
+	}
+
+	collectors := controllers.(GoalSystemWithMetrics).GetCollectors()
+	if testutil.ToFloat64(collectors[0]) != 1 {
+		t.Error("Recovered panic was not counted")
+	}
+}
+
+type InvalidHandlerController struct {
+	PingControllerWithoutMessages
+}
+
+func (y *InvalidHandlerController) HandleFoo(x int) {}
+
+type InterfaceHandlerController struct {
+	PingControllerWithoutMessages
+}
+
+func (y *InterfaceHandlerController) HandleMessage(ctx context.Context, message proto.Message) {}
+
+func TestInvalidHandlerSignaturesAreRejected(t *testing.T) {
+	for _, controller := range []Ping{&InvalidHandlerController{}, &InterfaceHandlerController{}} {
+		func() {
+			defer func() {
+				recovered := recover()
+				if recovered == nil || !strings.Contains(fmt.Sprint(recovered), "must be declared as") {
+					t.Errorf("Invalid handler signature of %T was not reported: %v", controller, recovered)
+				}
+			}()
+
+			NewEmptyServices().RegisterService(PingPathPrefix, NewPingServer(controller, nil), controller, nil)
+		}()
+	}
+}
+
+type ConfiguredController struct {
+	PingControllerWithoutMessages
+	Limits []string
+}
+
+func (y *ConfiguredController) Setup(ctx context.Context, server GoalServer, config *GoalConfig) error {
+	y.Limits = append(y.Limits, config.String("limit"))
+
+	return nil
+}
+
+func (y *ConfiguredController) Reconfigure(server GoalServer, oldConfig *GoalConfig, newConfig *GoalConfig) error {
+	y.Limits = append(y.Limits, oldConfig.String("limit")+"->"+newConfig.String("limit"))
+
+	return nil
+}
+
+func TestReconfigureChangedServices(t *testing.T) {
+	controller := &ConfiguredController{}
+	services := NewEmptyServices()
+	services.RegisterService(PingPathPrefix, NewPingServer(controller, nil), controller, nil)
+
+	server := NewTestServer()
+	server.Config.Set("goal.services.controllers.ping.limit", "10")
+	server.RegisterSystem(1, "services", services)
+
+	err := server.Start()
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	defer server.Stop()
+
+	config := NewEmptyConfig("test")
+	config.Set("goal.logger.level", "panic")
+	config.Set("goal.services.controllers.ping.limit", "20")
+
+	err = server.Reconfigure(config)
+	if err != nil {
+		t.Fatalf("Failed to reconfigure server: %v", err)
+	}
+
+	err = server.Reconfigure(config)
+	if err != nil {
+		t.Fatalf("Failed to reconfigure server: %v", err)
+	}
+
+	expected := []string{"10", "10->20"}
+	if len(controller.Limits) != len(expected) || controller.Limits[0] != expected[0] || controller.Limits[1] != expected[1] {
+		t.Errorf("Expected %v, got %v", expected, controller.Limits)
+	}
+}
+
+func benchmarkProcessMessages(b *testing.B, controller Ping) {
+	server, teardown := setup(controller, nil)
+	defer teardown()
+
+	controllers := (*server.GetSystem("controllers")).(GoalServices)
+	data, _ := ptypes.MarshalAny(&GoalPingRequest{Timestamp: 123})
+	envelope := &GoalMessageEnvelope{
+		Messages: []*any.Any{data},
+	}
+
+	ctx := context.Background()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		controllers.ProcessMessages(ctx, envelope)
+	}
+}
+
+func BenchmarkMethodHandlers(b *testing.B) {
+	benchmarkProcessMessages(b, &PingController{})
+}
+
+func BenchmarkTypedHandlers(b *testing.B) {
+	benchmarkProcessMessages(b, &TypedPingController{})
+}
@@ -0,0 +1,203 @@
+package systems
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+
+	"github.com/go-errors/errors"
+	"github.com/golang/protobuf/proto"
+)
+
+var ErrSessionNotFound = errors.New("Session not found")
+var ErrSessionClosed = errors.New("Session is closed")
+
+// GoalSession represents a client connected to the message stream endpoint
+type GoalSession interface {
+	GetID() string
+	GetRemoteAddress() string
+	GetContentType() string
+	GetCodec() GoalCodec
+	GetConnection() GoalMessageStreamConnection
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{})
+	Delete(key string)
+	Emit(ctx context.Context, messages ...proto.Message) error
+	OnClose(callback func())
+	Close() error
+}
+
+// GoalServiceWithConnect can be implemented by services which need to know
+// when a session is opened; returning an error closes the session
+type GoalServiceWithConnect interface {
+	OnConnect(ctx context.Context, session GoalSession) error
+}
+
+// GoalServiceWithDisconnect can be implemented by services which need
+// to know when a session is closed
+type GoalServiceWithDisconnect interface {
+	OnDisconnect(ctx context.Context, session GoalSession)
+}
+
+type sessionContextKey struct{}
+
+type session struct {
+	id            string
+	remoteAddress string
+	codec         GoalCodec
+	conn          GoalMessageStreamConnection
+	emitter       GoalServiceEmitter
+	attributes    map[string]interface{}
+	onClose       []func()
+	closed        bool
+	lock          sync.RWMutex
+	closeOnce     sync.Once
+}
+
+// NewSession creates a session for a message stream connection; messages
+// emitted through the session are sent by the given emitter, and encoded
+// with the codec negotiated by the client
+func NewSession(conn GoalMessageStreamConnection, remoteAddress string, codec GoalCodec, emitter GoalServiceEmitter) GoalSession {
+	return &session{
+		id:            newSessionID(),
+		remoteAddress: remoteAddress,
+		codec:         codec,
+		conn:          conn,
+		emitter:       emitter,
+		attributes:    map[string]interface{}{},
+	}
+}
+
+// WithSession returns a copy of the context carrying the session
+func WithSession(ctx context.Context, session GoalSession) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, session)
+}
+
+// GetSession returns the session found in the context of a message
+// handler, or nil if the context does not carry one
+func GetSession(ctx context.Context) GoalSession {
+	session, _ := ctx.Value(sessionContextKey{}).(GoalSession)
+
+	return session
+}
+
+// GetSessionAttribute returns the attribute stored under key, if it is of type T
+func GetSessionAttribute[T any](session GoalSession, key string) (T, bool) {
+	var typed T
+
+	value, found := session.Get(key)
+	if !found {
+		return typed, false
+	}
+
+	typed, ok := value.(T)
+
+	return typed, ok
+}
+
+func (session *session) GetID() string {
+	return session.id
+}
+
+func (session *session) GetRemoteAddress() string {
+	return session.remoteAddress
+}
+
+func (session *session) GetContentType() string {
+	return session.codec.GetMediaType()
+}
+
+func (session *session) GetCodec() GoalCodec {
+	return session.codec
+}
+
+func (session *session) GetConnection() GoalMessageStreamConnection {
+	return session.conn
+}
+
+func (session *session) Get(key string) (interface{}, bool) {
+	session.lock.RLock()
+	defer session.lock.RUnlock()
+
+	value, found := session.attributes[key]
+
+	return value, found
+}
+
+func (session *session) Set(key string, value interface{}) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	session.attributes[key] = value
+}
+
+func (session *session) Delete(key string) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	delete(session.attributes, key)
+}
+
+// Emit sends messages to the session; when the context comes from an
+// envelope received from this session, the messages reply to it.
+// ErrSessionClosed is returned once the session is closed
+func (session *session) Emit(ctx context.Context, messages ...proto.Message) error {
+	session.lock.RLock()
+	closed := session.closed
+	session.lock.RUnlock()
+
+	if closed {
+		return ErrSessionClosed
+	}
+
+	if GetSession(ctx) != GoalSession(session) {
+		ctx = WithMessageID(WithSession(ctx, session), 0)
+	}
+
+	return session.emitter(ctx, messages...)
+}
+
+// OnClose registers a callback called once the session is closed; it
+// is called right away if the session is already closed
+func (session *session) OnClose(callback func()) {
+	session.lock.Lock()
+	closed := session.closed
+	if !closed {
+		session.onClose = append(session.onClose, callback)
+	}
+	session.lock.Unlock()
+
+	if closed {
+		callback()
+	}
+}
+
+func (session *session) Close() error {
+	err := error(nil)
+	session.closeOnce.Do(func() {
+		err = session.conn.Close()
+
+		session.lock.Lock()
+		session.closed = true
+		callbacks := session.onClose
+		session.onClose = nil
+		session.lock.Unlock()
+
+		for _, callback := range callbacks {
+			callback()
+		}
+	})
+
+	return err
+}
+
+func newSessionID() string {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,148 @@
+package systems_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/Wizcorp/goal/src/proto"
+	. "github.com/Wizcorp/goal/src/systems"
+)
+
+type GuardedController struct {
+	PingControllerWithoutMessages
+	Disconnected []string
+}
+
+func (y *GuardedController) OnConnect(ctx context.Context, session GoalSession) error {
+	if session.GetRemoteAddress() == "banned" {
+		return errors.New("banned")
+	}
+
+	session.Set("userID", 42)
+
+	return nil
+}
+
+func (y *GuardedController) OnDisconnect(ctx context.Context, session GoalSession) {
+	y.Disconnected = append(y.Disconnected, session.GetID())
+}
+
+func TestSessionHooks(t *testing.T) {
+	controller := &GuardedController{}
+	server, teardown := setup(controller, nil)
+	defer teardown()
+
+	controllers := (*server.GetSystem("controllers")).(GoalServices)
+
+	banned := NewSession(&recordingConnection{}, "banned", &JSONCodec{}, controllers.EmitMessages)
+	if controllers.Connect(context.Background(), banned) == nil {
+		t.Error("Session was not refused by OnConnect")
+	}
+
+	session := NewSession(&recordingConnection{}, "127.0.0.1:1234", &JSONCodec{}, controllers.EmitMessages)
+	ctx := WithSession(context.Background(), session)
+
+	err := controllers.Connect(ctx, session)
+	if err != nil {
+		t.Fatalf("Session was refused: %v", err)
+	}
+
+	if GetSession(ctx) != session {
+		t.Error("Session could not be retrieved from the context")
+	}
+
+	userID, ok := GetSessionAttribute[int](session, "userID")
+	if !ok || userID != 42 {
+		t.Errorf("Unexpected session attribute: %v", userID)
+	}
+
+	if _, ok := GetSessionAttribute[string](session, "userID"); ok {
+		t.Error("Session attribute was returned with the wrong type")
+	}
+
+	controllers.Disconnect(ctx, session)
+	if len(controller.Disconnected) != 1 || controller.Disconnected[0] != session.GetID() {
+		t.Errorf("OnDisconnect was not called: %v", controller.Disconnected)
+	}
+}
+
+type CountingController struct {
+	PingControllerWithoutMessages
+	open *int
+}
+
+func (y *CountingController) OnConnect(ctx context.Context, session GoalSession) error {
+	*y.open++
+
+	return nil
+}
+
+func (y *CountingController) OnDisconnect(ctx context.Context, session GoalSession) {
+	*y.open--
+}
+
+func TestRefusedSessionsAreDisconnected(t *testing.T) {
+	open := 0
+	services := NewEmptyServices()
+
+	for _, path := range []string{"/first", "/second", "/third"} {
+		controller := &CountingController{open: &open}
+		services.RegisterService(path, NewPingServer(controller, nil), controller, nil)
+	}
+
+	guard := &GuardedController{}
+	services.RegisterService("/guard", NewPingServer(guard, nil), guard, nil)
+
+	// Services are connected in no particular order, so try a few times
+	for i := 0; i < 20; i++ {
+		session := NewSession(&recordingConnection{}, "banned", &JSONCodec{}, services.EmitMessages)
+		if services.Connect(context.Background(), session) == nil {
+			t.Fatal("Session was not refused by OnConnect")
+		}
+
+		if open != 0 {
+			t.Fatalf("%d services were not disconnected from the refused session", open)
+		}
+	}
+}
+
+func TestSessionRegistry(t *testing.T) {
+	controller := &GuardedController{}
+	server, teardown := setup(controller, nil)
+	defer teardown()
+
+	controllers := (*server.GetSystem("controllers")).(GoalServices)
+
+	conn := &recordingConnection{}
+	session := NewSession(conn, "127.0.0.1:1234", &ProtobufCodec{}, controllers.EmitMessages)
+	controllers.Connect(context.Background(), session)
+
+	found, ok := controllers.FindSession(session.GetID())
+	if !ok || found != session {
+		t.Error("Session could not be found by ID")
+	}
+
+	sessions := controllers.FindSessions("userID", 42)
+	if len(sessions) != 1 || sessions[0] != session {
+		t.Errorf("Session could not be found by attribute: %v", sessions)
+	}
+
+	err := controllers.EmitTo(context.Background(), session.GetID(), &GoalPingResponse{Timestamp: 1})
+	if err != nil || len(conn.Messages) != 1 {
+		t.Fatalf("Failed to push to session: %v", err)
+	}
+
+	session.Close()
+
+	if session.Emit(context.Background(), &GoalPingResponse{Timestamp: 2}) != ErrSessionClosed {
+		t.Error("Emitting to a closed session should fail")
+	}
+
+	controllers.Disconnect(context.Background(), session)
+
+	err = controllers.EmitTo(context.Background(), session.GetID(), &GoalPingResponse{Timestamp: 3})
+	if err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package systems
+
+import (
+	"sync"
+	"time"
+)
+
+type Status int
+
+const (
+	UpStatus Status = iota + 1
+	DownStatus
+	StartingStatus
+	DegradedStatus
+	StoppingStatus
+	FailedStatus
+)
+
+var statusNames = map[Status]string{
+	UpStatus:       "up",
+	DownStatus:     "down",
+	StartingStatus: "starting",
+	DegradedStatus: "degraded",
+	StoppingStatus: "stopping",
+	FailedStatus:   "failed",
+}
+
+func (status Status) String() string {
+	name, found := statusNames[status]
+	if !found {
+		return "unknown"
+	}
+
+	return name
+}
+
+func (status Status) MarshalText() ([]byte, error) {
+	return []byte(status.String()), nil
+}
+
+// IsRunning returns true if a system with this status is serving,
+// even if only partially
+func (status Status) IsRunning() bool {
+	return status == UpStatus || status == DegradedStatus
+}
+
+// GoalSystemState describes the status of a system, why it is
+// in this status and since when
+type GoalSystemState struct {
+	Status Status    `json:"status"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+// GoalSystemWithState can be implemented by systems to report
+// the reason behind their current status
+type GoalSystemWithState interface {
+	GetState() GoalSystemState
+}
+
+// GoalStatusTracker can be embedded in systems to keep track of their
+// status; it implements both GetStatus and GetState, and reports
+// DownStatus until SetStatus is first called
+type GoalStatusTracker struct {
+	lock  sync.RWMutex
+	state GoalSystemState
+}
+
+func (tracker *GoalStatusTracker) SetStatus(status Status, reason string) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	tracker.state = GoalSystemState{
+		Status: status,
+		Reason: reason,
+		Since:  time.Now(),
+	}
+}
+
+func (tracker *GoalStatusTracker) GetStatus() Status {
+	return tracker.GetState().Status
+}
+
+func (tracker *GoalStatusTracker) GetState() GoalSystemState {
+	tracker.lock.RLock()
+	defer tracker.lock.RUnlock()
+
+	if tracker.state.Status == 0 {
+		return GoalSystemState{Status: DownStatus}
+	}
+
+	return tracker.state
+}
@@ -0,0 +1,180 @@
+package systems
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// httpStreamConfig holds the limits applied to message stream connections;
+// zero durations disable the matching check
+type httpStreamConfig struct {
+	PingInterval   time.Duration `config:"pingInterval" default:"30s"`
+	PongTimeout    time.Duration `config:"pongTimeout" default:"10s"`
+	IdleTimeout    time.Duration `config:"idleTimeout" default:"5m"`
+	WriteTimeout   time.Duration `config:"writeTimeout" default:"10s"`
+	MaxMessageSize int64         `config:"maxMessageSize" default:"65536" validate:"min=1"`
+	AllowedOrigins []string      `config:"allowedOrigins"`
+	Compression    bool          `config:"compression"`
+}
+
+// streamWatch pings a connection, and closes it once the client stops
+// answering or stops sending messages
+type streamWatch struct {
+	conn         *websocket.Conn
+	settings     *httpStreamConfig
+	lastActivity time.Time
+	reason       string
+	done         chan struct{}
+	lock         sync.Mutex
+}
+
+func newUpgrader(settings *httpStreamConfig) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		EnableCompression: settings.Compression,
+		CheckOrigin:       newOriginChecker(settings.AllowedOrigins),
+	}
+}
+
+// newOriginChecker accepts requests without Origin header and requests from
+// allowed origins; "*" allows any origin, and an empty list only allows
+// requests coming from the same host
+func newOriginChecker(origins []string) func(r *http.Request) bool {
+	if len(origins) == 0 {
+		return nil
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+
+		for _, allowed := range origins {
+			if allowed == "*" || strings.EqualFold(allowed, origin) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+func newStreamWatch(conn *websocket.Conn, settings *httpStreamConfig) *streamWatch {
+	watch := &streamWatch{
+		conn:         conn,
+		settings:     settings,
+		lastActivity: time.Now(),
+		done:         make(chan struct{}),
+	}
+
+	conn.SetReadLimit(settings.MaxMessageSize)
+	conn.SetPongHandler(func(string) error {
+		watch.extendReadDeadline()
+		return nil
+	})
+
+	watch.extendReadDeadline()
+
+	return watch
+}
+
+// Touch records that a message was received from the client
+func (watch *streamWatch) Touch() {
+	watch.lock.Lock()
+	watch.lastActivity = time.Now()
+	watch.lock.Unlock()
+
+	watch.extendReadDeadline()
+}
+
+// Reason returns why the watch closed the connection, if it did
+func (watch *streamWatch) Reason() string {
+	watch.lock.Lock()
+	defer watch.lock.Unlock()
+
+	return watch.reason
+}
+
+func (watch *streamWatch) Stop() {
+	close(watch.done)
+}
+
+// Run sends pings and checks for idle connections until the watch is
+// stopped; closeSession is called when the connection must be closed
+func (watch *streamWatch) Run(closeSession func()) {
+	var ping <-chan time.Time
+	if watch.settings.PingInterval > 0 {
+		ticker := time.NewTicker(watch.settings.PingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	var idle <-chan time.Time
+	var idleTimer *time.Timer
+	if watch.settings.IdleTimeout > 0 {
+		idleTimer = time.NewTimer(watch.settings.IdleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+
+	for {
+		select {
+		case <-watch.done:
+			return
+		case <-ping:
+			deadline := time.Now().Add(watch.settings.PongTimeout)
+			watch.conn.WriteControl(websocket.PingMessage, nil, deadline)
+		case <-idle:
+			watch.lock.Lock()
+			remaining := watch.settings.IdleTimeout - time.Since(watch.lastActivity)
+			watch.lock.Unlock()
+
+			if remaining > 0 {
+				idleTimer.Reset(remaining)
+				continue
+			}
+
+			watch.Close(websocket.CloseGoingAway, "idle timeout")
+			closeSession()
+			return
+		}
+	}
+}
+
+// Close sends a close frame to the client; the connection itself
+// is closed by the caller
+func (watch *streamWatch) Close(code int, reason string) {
+	watch.lock.Lock()
+	if watch.reason == "" {
+		watch.reason = reason
+	}
+	watch.lock.Unlock()
+
+	deadline := time.Now().Add(time.Second)
+	if watch.settings.WriteTimeout > 0 {
+		deadline = time.Now().Add(watch.settings.WriteTimeout)
+	}
+
+	watch.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+}
+
+func (watch *streamWatch) extendReadDeadline() {
+	if watch.settings.PingInterval <= 0 {
+		return
+	}
+
+	watch.conn.SetReadDeadline(time.Now().Add(watch.settings.PingInterval + watch.settings.PongTimeout))
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+
+	return ok && netErr.Timeout()
+}
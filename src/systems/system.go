@@ -1,34 +1,58 @@
 package systems
 
-import . "github.com/Wizcorp/goal/src/api"
+import (
+	"context"
 
-type Status int
-
-const (
-	UpStatus Status = iota + 1
-	DownStatus
+	. "github.com/Wizcorp/goal/src/api"
 )
 
 type GoalSystem interface {
-	Setup(server GoalServer, config *GoalConfig) error
-	Teardown(server GoalServer, config *GoalConfig) error
+	Setup(ctx context.Context, server GoalServer, config *GoalConfig) error
+	Teardown(ctx context.Context, server GoalServer, config *GoalConfig) error
 	GetStatus() Status
 }
 
+// GoalSystemWithDependencies can be implemented by systems which need other
+// systems to be set up before them. Systems which do not implement it
+// depend on every system registered with a lower runlevel.
+type GoalSystemWithDependencies interface {
+	GetDependencies() []string
+}
+
+// GoalSystemWithReconfigure can be implemented by systems which are able
+// to apply configuration changes without being restarted
+type GoalSystemWithReconfigure interface {
+	Reconfigure(server GoalServer, oldConfig *GoalConfig, newConfig *GoalConfig) error
+}
+
 type GoalRunlevel map[string]GoalSystem
 
+// GoalSystemFactory creates a new instance of a system for every server
+type GoalSystemFactory func() GoalSystem
+
 type systemRecord struct {
 	Runlevel int
 	Name     string
-	System   GoalSystem
+	Factory  GoalSystemFactory
 }
 
-var systems = []systemRecord{}
+var defaultSystems = []systemRecord{}
 
+// RegisterSystem adds a system to the ones set up by servers created
+// with NewServer. The same instance is shared by all of those servers;
+// use RegisterSystemFactory for systems which hold state.
 func RegisterSystem(runlevel int, name string, system GoalSystem) {
-	systems = append(systems, systemRecord{
+	RegisterSystemFactory(runlevel, name, func() GoalSystem {
+		return system
+	})
+}
+
+// RegisterSystemFactory adds a system to the ones set up by servers
+// created with NewServer; each server gets its own instance
+func RegisterSystemFactory(runlevel int, name string, factory GoalSystemFactory) {
+	defaultSystems = append(defaultSystems, systemRecord{
 		Runlevel: runlevel,
 		Name:     name,
-		System:   system,
+		Factory:  factory,
 	})
 }